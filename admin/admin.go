@@ -0,0 +1,358 @@
+// Package admin serves a JSON API for inspecting and controlling a running
+// server.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/akojo/legion/config"
+	"github.com/akojo/legion/handler"
+)
+
+// Controller gives access to configuration of a running server.
+type Controller interface {
+	// Config returns configuration currently in effect.
+	Config() *config.Config
+	// Reload re-reads configuration and replaces routes, returning an error
+	// if configuration is invalid.
+	Reload(reason string) error
+}
+
+// API serves admin endpoints under /api/.
+type API struct {
+	ctl       Controller
+	upstreams *handler.Upstreams
+	har       *handler.HARRecorder
+	logLevel  *slog.LevelVar
+	mux       *http.ServeMux
+
+	started  time.Time
+	requests atomic.Int64
+	inFlight atomic.Int64
+}
+
+// New returns an API controlling server through ctl, upstreams, HAR
+// recorder and logLevel.
+func New(ctl Controller, upstreams *handler.Upstreams, recorder *handler.HARRecorder, logLevel *slog.LevelVar) *API {
+	a := &API{
+		ctl:       ctl,
+		upstreams: upstreams,
+		har:       recorder,
+		logLevel:  logLevel,
+		mux:       http.NewServeMux(),
+		started:   time.Now(),
+	}
+	endpoints := map[string]endpoint{}
+	handle := func(path, method string, serve func(*http.Request) (any, error)) {
+		if endpoints[path] == nil {
+			endpoints[path] = endpoint{}
+			a.mux.Handle(path, endpoints[path])
+		}
+		endpoints[path][method] = serve
+	}
+	handle("/api/routes", "GET", a.routes)
+	handle("/api/upstreams", "GET", a.listUpstreams)
+	for _, state := range []handler.UpstreamState{handler.UpstreamEnabled, handler.UpstreamDraining, handler.UpstreamDisabled} {
+		handle("/api/upstreams/"+actions[state], "POST", func(r *http.Request) (any, error) {
+			return a.setUpstreamState(r, state)
+		})
+	}
+	handle("/api/config", "GET", a.config)
+	handle("/api/reload", "POST", a.reload)
+	handle("/api/loglevel", "GET", a.getLogLevel)
+	handle("/api/loglevel", "PUT", a.setLogLevel)
+	handle("/api/stats", "GET", a.stats)
+	handle("/api/har", "GET", a.harStatus)
+	handle("/api/har/start", "POST", a.startHAR)
+	handle("/api/har/stop", "POST", a.stopHAR)
+	handle("/api/har/entries", "GET", a.harEntries)
+	return a
+}
+
+// Actions setting upstream state.
+var actions = map[handler.UpstreamState]string{
+	handler.UpstreamEnabled:  "enable",
+	handler.UpstreamDraining: "drain",
+	handler.UpstreamDisabled: "disable",
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// Middleware counts requests served by next for stats.
+func (a *API) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.requests.Add(1)
+		a.inFlight.Add(1)
+		defer a.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiError is an error with HTTP status code.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func errorf(status int, format string, args ...any) error {
+	return &apiError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// endpoint serves JSON encoded values returned by functions by request
+// method.
+type endpoint map[string]func(*http.Request) (any, error)
+
+func (e endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve, ok := e[r.Method]
+	if !ok {
+		allowed := make([]string, 0, len(e))
+		for method := range e {
+			allowed = append(allowed, method)
+		}
+		slices.Sort(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+		return
+	}
+	err := checkRequest(r)
+	var value any
+	if err == nil {
+		value, err = serve(r)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			status = apiErr.status
+		}
+		writeJSON(w, status, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, value)
+}
+
+// checkRequest rejects requests a browser may have been tricked into
+// sending. Only IP addresses and localhost are accepted as Host, so that a
+// DNS name rebound to admin listener can't be used to read responses, and
+// requests changing state must have JSON content type and Origin, if any,
+// matching Host, which cross-site requests can't have without a CORS
+// preflight.
+func checkRequest(r *http.Request) error {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if _, err := netip.ParseAddr(host); err != nil && host != "localhost" && !strings.HasSuffix(host, ".localhost") {
+		return errorf(http.StatusForbidden, "%s: host not allowed", r.Host)
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			return errorf(http.StatusForbidden, "%s: cross-origin request not allowed", origin)
+		}
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return errorf(http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+	}
+	return nil
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(value)
+}
+
+type route struct {
+	Source string `json:"source"`
+	Type   string `json:"type"`
+	Target string `json:"target"`
+	Mode   string `json:"mode,omitempty"`
+}
+
+func (a *API) routes(*http.Request) (any, error) {
+	conf := a.ctl.Config()
+	routes := []route{}
+	for _, r := range conf.Routes.Static {
+		routes = append(routes, route{Source: r.Source, Type: "static", Target: r.Target})
+	}
+	for _, r := range conf.Routes.Proxy {
+		routes = append(routes, route{Source: r.Source, Type: "proxy", Target: r.Target, Mode: r.Mode})
+	}
+	return routes, nil
+}
+
+// targets returns upstream targets of proxy routes in current
+// configuration.
+func (a *API) targets() []string {
+	var targets []string
+	for _, r := range a.ctl.Config().Routes.Proxy {
+		if !slices.Contains(targets, r.Target) {
+			targets = append(targets, r.Target)
+		}
+	}
+	return targets
+}
+
+func (a *API) listUpstreams(*http.Request) (any, error) {
+	upstreams := []handler.UpstreamStatus{}
+	for _, target := range a.targets() {
+		upstreams = append(upstreams, a.upstreams.Status(target))
+	}
+	return upstreams, nil
+}
+
+func (a *API) setUpstreamState(r *http.Request, state handler.UpstreamState) (any, error) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		return nil, errorf(http.StatusBadRequest, "missing target")
+	}
+	if !slices.Contains(a.targets(), target) {
+		return nil, errorf(http.StatusNotFound, "%s: no such upstream", target)
+	}
+	if err := a.upstreams.SetState(target, state); err != nil {
+		return nil, errorf(http.StatusBadRequest, "%w", err)
+	}
+	return a.upstreams.Status(target), nil
+}
+
+// config returns configuration in effect, with field names as in
+// configuration file.
+func (a *API) config(*http.Request) (any, error) {
+	text, err := yaml.Marshal(a.ctl.Config())
+	if err != nil {
+		return nil, err
+	}
+	var conf map[string]any
+	if err := yaml.Unmarshal(text, &conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+type reloadResponse struct {
+	Status string `json:"status"`
+}
+
+func (a *API) reload(*http.Request) (any, error) {
+	if err := a.ctl.Reload("admin API"); err != nil {
+		return nil, errorf(http.StatusUnprocessableEntity, "invalid configuration: %w", err)
+	}
+	return reloadResponse{"reloaded"}, nil
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+func (a *API) getLogLevel(*http.Request) (any, error) {
+	return logLevel{a.logLevel.Level().String()}, nil
+}
+
+func (a *API) setLogLevel(r *http.Request) (any, error) {
+	var body logLevel
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 4096)).Decode(&body); err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid request body: %w", err)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(body.Level)); err != nil {
+		return nil, errorf(http.StatusBadRequest, "%w", err)
+	}
+	slog.Info("log level changed", "from", a.logLevel.Level(), "to", level)
+	a.logLevel.Set(level)
+	return logLevel{level.String()}, nil
+}
+
+func (a *API) harStatus(*http.Request) (any, error) {
+	return a.har.Status(), nil
+}
+
+func (a *API) startHAR(r *http.Request) (any, error) {
+	var opts handler.HARCapture
+	err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 4096)).Decode(&opts)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, errorf(http.StatusBadRequest, "invalid request body: %w", err)
+	}
+	for _, source := range opts.Routes {
+		if !slices.ContainsFunc(a.ctl.Config().Routes.Proxy, func(r config.ProxyRoute) bool { return r.Source == source }) {
+			return nil, errorf(http.StatusNotFound, "%s: no such proxy route", source)
+		}
+	}
+	if err := a.har.Start(opts); errors.Is(err, handler.ErrCaptureInProgress) {
+		return nil, errorf(http.StatusConflict, "%w", err)
+	} else if err != nil {
+		return nil, errorf(http.StatusBadRequest, "%w", err)
+	}
+	return a.har.Status(), nil
+}
+
+func (a *API) stopHAR(*http.Request) (any, error) {
+	doc, err := a.har.Stop()
+	if err != nil {
+		return nil, errorf(http.StatusConflict, "%w", err)
+	}
+	return doc, nil
+}
+
+func (a *API) harEntries(*http.Request) (any, error) {
+	doc, err := a.har.HAR()
+	if err != nil {
+		return nil, errorf(http.StatusConflict, "%w", err)
+	}
+	return doc, nil
+}
+
+type stats struct {
+	StartTime     time.Time `json:"start_time"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Requests      int64     `json:"requests"`
+	InFlight      int64     `json:"in_flight"`
+	Goroutines    int       `json:"goroutines"`
+	HeapBytes     uint64    `json:"heap_bytes"`
+	GoVersion     string    `json:"go_version"`
+}
+
+func (a *API) stats(*http.Request) (any, error) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return stats{
+		StartTime:     a.started,
+		UptimeSeconds: int64(time.Since(a.started).Seconds()),
+		Requests:      a.requests.Load(),
+		InFlight:      a.inFlight.Load(),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapBytes:     mem.HeapAlloc,
+		GoVersion:     runtime.Version(),
+	}, nil
+}
@@ -0,0 +1,228 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akojo/legion/admin"
+	"github.com/akojo/legion/config"
+	"github.com/akojo/legion/handler"
+)
+
+type controller struct {
+	conf      *config.Config
+	reloadErr error
+	reloads   int
+}
+
+func (c *controller) Config() *config.Config {
+	return c.conf
+}
+
+func (c *controller) Reload(string) error {
+	c.reloads++
+	return c.reloadErr
+}
+
+func newAPI() (*admin.API, *controller, *slog.LevelVar) {
+	ctl := &controller{conf: &config.Config{
+		Routes: config.Routes{
+			Static: []config.StaticRoute{{Source: "/", Target: "/var/www"}},
+			Proxy:  []config.ProxyRoute{{Source: "/api/", Target: "http://localhost:8080", Mode: "grpc"}},
+		},
+	}}
+	logLevel := &slog.LevelVar{}
+	return admin.New(ctl, handler.NewUpstreams(), handler.NewHARRecorder(), logLevel), ctl, logLevel
+}
+
+func TestRoutes(t *testing.T) {
+	api, _, _ := newAPI()
+	var routes []map[string]string
+	if status := call(t, api, "GET", "/api/routes", "", &routes); status != http.StatusOK {
+		t.Fatalf("want 200, got %d", status)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("want 2 routes, got %v", routes)
+	}
+	if r := routes[1]; r["type"] != "proxy" || r["target"] != "http://localhost:8080" || r["mode"] != "grpc" {
+		t.Errorf("got %v", r)
+	}
+}
+
+func TestSetUpstreamState(t *testing.T) {
+	api, _, _ := newAPI()
+	var status handler.UpstreamStatus
+	if code := call(t, api, "POST", "/api/upstreams/drain?target=http://localhost:8080", "", &status); code != http.StatusOK {
+		t.Fatalf("want 200, got %d", code)
+	}
+	if status.State != handler.UpstreamDraining {
+		t.Errorf("want draining, got %s", status.State)
+	}
+
+	var upstreams []handler.UpstreamStatus
+	call(t, api, "GET", "/api/upstreams", "", &upstreams)
+	if len(upstreams) != 1 || upstreams[0].State != handler.UpstreamDraining {
+		t.Errorf("got %+v", upstreams)
+	}
+
+	if code := call(t, api, "POST", "/api/upstreams/disable?target=http://localhost:9090", "", nil); code != http.StatusNotFound {
+		t.Errorf("unknown upstream: want 404, got %d", code)
+	}
+	if code := call(t, api, "POST", "/api/upstreams/disable", "", nil); code != http.StatusBadRequest {
+		t.Errorf("missing target: want 400, got %d", code)
+	}
+}
+
+func TestReload(t *testing.T) {
+	api, ctl, _ := newAPI()
+	if code := call(t, api, "POST", "/api/reload", "", nil); code != http.StatusOK || ctl.reloads != 1 {
+		t.Errorf("want 200 and reload, got %d and %d reloads", code, ctl.reloads)
+	}
+
+	ctl.reloadErr = errors.New("invalid route")
+	var resp map[string]string
+	if code := call(t, api, "POST", "/api/reload", "", &resp); code != http.StatusUnprocessableEntity {
+		t.Errorf("want 422, got %d", code)
+	}
+	if !strings.Contains(resp["error"], "invalid route") {
+		t.Errorf("want reload error, got %v", resp)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	api, _, logLevel := newAPI()
+	var resp map[string]string
+	if code := call(t, api, "PUT", "/api/loglevel", `{"level":"debug"}`, &resp); code != http.StatusOK {
+		t.Fatalf("want 200, got %d", code)
+	}
+	if logLevel.Level() != slog.LevelDebug || resp["level"] != "DEBUG" {
+		t.Errorf("want DEBUG, got %s and %v", logLevel.Level(), resp)
+	}
+	if code := call(t, api, "PUT", "/api/loglevel", `{"level":"loud"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("invalid level: want 400, got %d", code)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	api, _, _ := newAPI()
+	var conf map[string]any
+	if code := call(t, api, "GET", "/api/config", "", &conf); code != http.StatusOK {
+		t.Fatalf("want 200, got %d", code)
+	}
+	routes, _ := conf["routes"].(map[string]any)
+	if proxy, _ := routes["proxy"].([]any); len(proxy) != 1 {
+		t.Errorf("want proxy routes by configuration file names, got %v", conf)
+	}
+}
+
+func TestBrowserRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header map[string]string
+		want   int
+	}{
+		{"rebound host", "GET", "http://legion.example/api/routes", nil, http.StatusForbidden},
+		{"IP address host", "GET", "http://127.0.0.1:9100/api/routes", nil, http.StatusOK},
+		{"IPv6 address host", "GET", "http://[::1]:9100/api/routes", nil, http.StatusOK},
+		{"form post", "POST", "http://localhost/api/reload", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, http.StatusUnsupportedMediaType},
+		{"no content type", "POST", "http://localhost/api/reload", nil, http.StatusUnsupportedMediaType},
+		{"cross-origin", "POST", "http://localhost/api/reload", map[string]string{"Content-Type": "application/json", "Origin": "http://legion.example"}, http.StatusForbidden},
+		{"same origin", "POST", "http://localhost:9100/api/reload", map[string]string{"Content-Type": "application/json", "Origin": "http://localhost:9100"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, _, _ := newAPI()
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("want %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	api, _, _ := newAPI()
+	req := httptest.NewRequest("DELETE", "/api/loglevel", nil)
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("want 405, got %d", rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, PUT" {
+		t.Errorf("want Allow: GET, PUT, got %q", allow)
+	}
+}
+
+func TestHARCapture(t *testing.T) {
+	api, _, _ := newAPI()
+	if code := call(t, api, "POST", "/api/har/stop", "", nil); code != http.StatusConflict {
+		t.Errorf("stop without capture: want 409, got %d", code)
+	}
+	if code := call(t, api, "POST", "/api/har/start", `{"routes":["/static/"]}`, nil); code != http.StatusNotFound {
+		t.Errorf("unknown route: want 404, got %d", code)
+	}
+
+	var status handler.HARStatus
+	if code := call(t, api, "POST", "/api/har/start", `{"routes":["/api/"],"path":"/api/v1/*"}`, &status); code != http.StatusOK {
+		t.Fatalf("start: want 200, got %d", code)
+	}
+	if !status.Capturing || status.Capture.Path != "/api/v1/*" {
+		t.Errorf("status: got %+v", status)
+	}
+	if code := call(t, api, "POST", "/api/har/start", "", nil); code != http.StatusConflict {
+		t.Errorf("start during capture: want 409, got %d", code)
+	}
+
+	var doc map[string]map[string]any
+	if code := call(t, api, "POST", "/api/har/stop", "", &doc); code != http.StatusOK {
+		t.Fatalf("stop: want 200, got %d", code)
+	}
+	if doc["log"]["version"] != "1.2" {
+		t.Errorf("want HAR 1.2, got %v", doc)
+	}
+}
+
+func TestStats(t *testing.T) {
+	api, _, _ := newAPI()
+	h := api.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var stats map[string]any
+	call(t, api, "GET", "/api/stats", "", &stats)
+	if stats["requests"] != 1.0 || stats["in_flight"] != 0.0 {
+		t.Errorf("got %v", stats)
+	}
+}
+
+// call sends request to api, decoding JSON response to resp if not nil, and
+// returns response status.
+func call(t *testing.T, api *admin.API, method, path, body string, resp any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "http://localhost"+path, strings.NewReader(body))
+	if method != "GET" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	api.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: want JSON, got %s", method, path, ct)
+	}
+	if resp != nil {
+		if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code
+}
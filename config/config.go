@@ -1,14 +1,170 @@
 package config
 
 import (
+	"fmt"
 	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Addr     string   `yaml:"listen"`
-	LogLevel LogLevel `yaml:"loglevel"`
-	Routes   Routes   `yaml:"routes"`
-	TLS      TLS      `yaml:"tls"`
+	Addr      string     `yaml:"listen"`
+	Listeners []Listener `yaml:"listeners"`
+	LogLevel  LogLevel   `yaml:"loglevel"`
+	Log       Log        `yaml:"log"`
+	AccessLog AccessLog  `yaml:"access_log"`
+	Routes    Routes     `yaml:"routes"`
+	TLS       TLS        `yaml:"tls"`
+	HTTP3     HTTP3      `yaml:"http3"`
+	H2C       bool       `yaml:"h2c"`
+	Reload    Reload     `yaml:"reload"`
+	Timeouts  Timeouts   `yaml:"timeouts"`
+	Limits    Limits     `yaml:"limits"`
+	Admin     Admin      `yaml:"admin"`
+	Tracing   Tracing    `yaml:"tracing"`
+	Health    Health     `yaml:"health"`
+	Dump      Dump       `yaml:"dump"`
+
+	TrustedProxies []CIDR `yaml:"trusted_proxies"`
+
+	MaxHeaderBytes int `yaml:"max_header_bytes"`
+
+	// File configuration was read from, if any.
+	File string `yaml:"-"`
+}
+
+type Reload struct {
+	Watch    bool          `yaml:"watch"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+type Timeouts struct {
+	ReadHeader time.Duration `yaml:"read_header"`
+	Read       time.Duration `yaml:"read"`
+	Write      time.Duration `yaml:"write"`
+	Idle       time.Duration `yaml:"idle"`
+	Shutdown   time.Duration `yaml:"shutdown"`
+}
+
+type Limits struct {
+	MaxConns      int `yaml:"max_connections"`
+	MaxConnsPerIP int `yaml:"max_connections_per_ip"`
+}
+
+// Admin configures a separate listener for administrative endpoints, which
+// should not be reachable from public networks. Listener must be on a
+// loopback address or a Unix domain socket unless AllowRemote is set.
+type Admin struct {
+	Addr        string `yaml:"listen"`
+	AllowRemote bool   `yaml:"allow_remote"`
+	Metrics     bool   `yaml:"metrics"`
+	API         bool   `yaml:"api"`
+}
+
+// Health configures liveness and readiness endpoints served on all
+// listeners. Requests to them are not logged unless AccessLog is set.
+type Health struct {
+	Enabled   bool   `yaml:"enabled"`
+	Liveness  string `yaml:"liveness"`
+	Readiness string `yaml:"readiness"`
+	// Number of healthy upstreams each proxy route needs for server to be
+	// ready, unless set for route.
+	MinHealthyUpstreams int `yaml:"min_healthy_upstreams"`
+	// Time after last error after which a failed upstream counts as healthy
+	// again, so that requests are let through to find out if it recovered.
+	UpstreamRecovery time.Duration `yaml:"upstream_recovery"`
+	AccessLog        bool          `yaml:"access_log"`
+}
+
+// Tracing configures exporting OpenTelemetry spans to a collector.
+type Tracing struct {
+	Enabled     bool         `yaml:"enabled"`
+	Protocol    OTLPProtocol `yaml:"protocol"`
+	Endpoint    string       `yaml:"endpoint"`
+	ServiceName string       `yaml:"service_name"`
+	SampleRatio *float64     `yaml:"sample_ratio"`
+}
+
+// OTLPProtocol is protocol used for exporting spans, either grpc or http.
+type OTLPProtocol string
+
+func (p *OTLPProtocol) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "grpc", "http":
+		*p = OTLPProtocol(text)
+		return nil
+	}
+	return fmt.Errorf("%s: unknown OTLP protocol, expected grpc or http", text)
+}
+
+type HTTP3 struct {
+	Enabled bool   `yaml:"enabled"`
+	Addr    string `yaml:"listen"`
+}
+
+// Listener configures a single address to listen on.
+type Listener struct {
+	Name  string   `yaml:"name"`
+	Addr  string   `yaml:"listen"`
+	Mode  FileMode `yaml:"mode"`
+	Owner string   `yaml:"owner"`
+	Group string   `yaml:"group"`
+
+	ProxyProtocol *ProxyProtocol `yaml:"proxy_protocol"`
+	MaxConns      int            `yaml:"max_connections"`
+}
+
+type ProxyProtocol struct {
+	Trusted []CIDR `yaml:"trusted"`
+}
+
+// CIDR is an IP address range in CIDR notation, e.g. 10.0.0.0/8. A single
+// IP address is a range of one address.
+type CIDR struct {
+	netip.Prefix
+}
+
+func (c *CIDR) UnmarshalText(text []byte) error {
+	if addr, err := netip.ParseAddr(string(text)); err == nil {
+		c.Prefix = netip.PrefixFrom(addr, addr.BitLen())
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(string(text))
+	if err != nil {
+		return fmt.Errorf("%s: invalid CIDR", text)
+	}
+	c.Prefix = prefix.Masked()
+	return nil
+}
+
+// FileMode is a file permission mode given as an octal number, e.g. 0660.
+type FileMode struct {
+	os.FileMode
+}
+
+func (m *FileMode) UnmarshalText(text []byte) error {
+	mode, err := strconv.ParseUint(string(text), 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("%s: invalid file mode", text)
+	}
+	m.FileMode = os.FileMode(mode)
+	return nil
+}
+
+func (m FileMode) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "%#o", uint32(m.FileMode)), nil
+}
+
+// AllListeners returns configured listeners, or a single listener on Addr
+// if none are configured.
+func (c *Config) AllListeners() []Listener {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+	return []Listener{{Addr: c.Addr}}
 }
 
 type LogLevel struct {
@@ -19,26 +175,133 @@ func (l *LogLevel) Set(value string) error {
 	return l.UnmarshalText([]byte(value))
 }
 
+// Log configures application and error logs. Logs are written to File if
+// set, to standard output otherwise.
+type Log struct {
+	Format LogFormat `yaml:"format"`
+	File   string    `yaml:"file"`
+	Rotate Rotate    `yaml:"rotate"`
+}
+
+// AccessLog configures request logs. Format is either one of formats
+// supported by logger.NewHandler or a template, and defaults to that of
+// application log. If Fields is set, only listed fields are logged.
+type AccessLog struct {
+	Format string   `yaml:"format"`
+	Fields []string `yaml:"fields"`
+	File   string   `yaml:"file"`
+	Rotate Rotate   `yaml:"rotate"`
+}
+
+// Rotate configures rotation of log files.
+type Rotate struct {
+	MaxSize    ByteSize `yaml:"max_size"`
+	Daily      bool     `yaml:"daily"`
+	MaxBackups int      `yaml:"max_backups"`
+	Compress   bool     `yaml:"compress"`
+}
+
+// ByteSize is a size in bytes, optionally with a K, M or G suffix for
+// kibibytes, mebibytes and gibibytes respectively.
+type ByteSize int64
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.TrimSuffix(strings.ToUpper(string(text)), "B")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("%s: invalid size", text)
+	}
+	*b = ByteSize(n * unit)
+	return nil
+}
+
+// LogFormat is a structured log format, either logfmt or json.
+type LogFormat string
+
+func (f *LogFormat) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "logfmt", "json":
+		*f = LogFormat(text)
+		return nil
+	}
+	return fmt.Errorf("%s: unknown log format, expected logfmt or json", text)
+}
+
 type Routes struct {
 	Static []StaticRoute `yaml:"static"`
 	Proxy  []ProxyRoute  `yaml:"proxy"`
 }
 
 type StaticRoute struct {
-	Source string `yaml:"source"`
-	Target string `yaml:"target"`
+	Source       string `yaml:"source"`
+	Target       string `yaml:"target"`
+	RouteOptions `yaml:",inline"`
 }
 
 type ProxyRoute struct {
-	Source string `yaml:"source"`
-	Target string `yaml:"target"`
+	Source       string `yaml:"source"`
+	Target       string `yaml:"target"`
+	Mode         string `yaml:"mode"`
+	RouteOptions `yaml:",inline"`
+
+	SendProxyProtocol int `yaml:"send_proxy_protocol"`
+
+	// Overrides health.min_healthy_upstreams for route.
+	MinHealthyUpstreams *int `yaml:"min_healthy_upstreams"`
+}
+
+// RouteOptions holds settings common to all route types.
+type RouteOptions struct {
+	ClientCert   string        `yaml:"client_cert"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	MaxConcurrentRequests int           `yaml:"max_concurrent_requests"`
+	Queue                 int           `yaml:"queue"`
+	QueueTimeout          time.Duration `yaml:"queue_timeout"`
+
+	// Overrides global dump settings for route.
+	Dump *Dump `yaml:"dump"`
+}
+
+// Dump configures logging full requests and responses for debugging.
+type Dump struct {
+	Enabled     bool     `yaml:"enabled"`
+	BodyBytes   ByteSize `yaml:"body_bytes"`
+	ShowSecrets bool     `yaml:"show_secrets"`
 }
 
 type TLS struct {
-	Certificates []Certificate `yaml:"certificates"`
+	Certificates          []Certificate `yaml:"certificates"`
+	ClientAuth            *ClientAuth   `yaml:"client_auth"`
+	Preset                string        `yaml:"preset"`
+	MinVersion            TLSVersion    `yaml:"min_version"`
+	CipherSuites          []CipherSuite `yaml:"cipher_suites"`
+	Curves                []Curve       `yaml:"curves"`
+	ALPN                  []string      `yaml:"alpn"`
+	SessionTicketRotation time.Duration `yaml:"session_ticket_rotation"`
+	OCSPStapling          bool          `yaml:"ocsp_stapling"`
 }
 
 type Certificate struct {
 	CertFile string `yaml:"certfile"`
 	KeyFile  string `yaml:"keyfile"`
 }
+
+type ClientAuth struct {
+	CAFile string         `yaml:"cafile"`
+	Mode   ClientAuthMode `yaml:"mode"`
+	Hosts  []string       `yaml:"hosts"`
+}
@@ -1,8 +1,12 @@
 package config_test
 
 import (
+	"crypto/tls"
 	"log/slog"
+	"net/netip"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/akojo/legion/config"
 )
@@ -18,7 +22,7 @@ func TestDefaultConfig(t *testing.T) {
 	if len(conf.Routes.Static) != 1 {
 		t.Errorf("Routes: want 1, got %v", conf.Routes.Static)
 	}
-	want := config.StaticRoute{"/", "."}
+	want := config.StaticRoute{Source: "/", Target: "."}
 	route := conf.Routes.Static[0]
 	if route != want {
 		t.Errorf("default: want %v, got %v", want, route)
@@ -44,7 +48,7 @@ func TestRouteFlag(t *testing.T) {
 	if len(conf.Routes.Static) != 1 {
 		t.Errorf("Routes: want 1, got %v", conf.Routes.Static)
 	}
-	want := config.StaticRoute{"/", "/www"}
+	want := config.StaticRoute{Source: "/", Target: "/www"}
 	route := conf.Routes.Static[0]
 	if route != want {
 		t.Errorf("default: want %v, got %v", want, route)
@@ -56,7 +60,7 @@ func TestRouteFlagWithURL(t *testing.T) {
 	if got := len(conf.Routes.Proxy); got != 1 {
 		t.Errorf("Routes: want 1, got %v", got)
 	}
-	want := config.ProxyRoute{"/", "http://example.com"}
+	want := config.ProxyRoute{Source: "/", Target: "http://example.com"}
 	route := conf.Routes.Proxy[0]
 	if route != want {
 		t.Errorf("default: want %v, got %v", want, route)
@@ -66,6 +70,28 @@ func TestRouteFlagWithURL(t *testing.T) {
 	}
 }
 
+func TestRouteFlagWithH2CURL(t *testing.T) {
+	conf := newConf(t, "-route", "/grpc=h2c://localhost:50051")
+	if got := len(conf.Routes.Proxy); got != 1 {
+		t.Fatalf("Routes: want 1, got %v", got)
+	}
+	want := config.ProxyRoute{Source: "/grpc", Target: "h2c://localhost:50051"}
+	if route := conf.Routes.Proxy[0]; route != want {
+		t.Errorf("want %v, got %v", want, route)
+	}
+}
+
+func TestRouteFlagWithUnixSocket(t *testing.T) {
+	conf := newConf(t, "-route", "/api=unix:/run/app.sock:/v1")
+	if got := len(conf.Routes.Proxy); got != 1 {
+		t.Fatalf("Routes: want 1, got %v", got)
+	}
+	want := config.ProxyRoute{Source: "/api", Target: "unix:/run/app.sock:/v1"}
+	if route := conf.Routes.Proxy[0]; route != want {
+		t.Errorf("want %v, got %v", want, route)
+	}
+}
+
 func TestConfigFile(t *testing.T) {
 	conf := newConf(t, "-config", "testdata/config.yml")
 	if conf.Addr != ":80" {
@@ -84,28 +110,361 @@ func TestConfigFile(t *testing.T) {
 		t.Errorf("TLS cert: want %s, got %s", cert, got)
 	}
 
-	static := config.StaticRoute{"/", "."}
+	static := config.StaticRoute{Source: "/", Target: ".", RouteOptions: config.RouteOptions{
+		MaxConcurrentRequests: 10,
+		Queue:                 5,
+		QueueTimeout:          500 * time.Millisecond,
+	}}
 	if got := len(conf.Routes.Static); got != 1 {
 		t.Errorf("static routes: want 1, got %d", got)
 	}
 	if got := conf.Routes.Static[0]; got != static {
-		t.Errorf("static route: want %s, got %s", static, got)
+		t.Errorf("static route: want %v, got %v", static, got)
 	}
 
 	proxies := []config.ProxyRoute{
-		{"/http", "http://example.com/"},
-		{"/https", "https://example.com/"},
+		{Source: "/http", Target: "http://example.com/", SendProxyProtocol: 2},
+		{
+			Source:       "/https",
+			Target:       "https://example.com/",
+			RouteOptions: config.RouteOptions{WriteTimeout: 5 * time.Minute},
+		},
 	}
 	if got := len(conf.Routes.Proxy); got != 2 {
 		t.Errorf("proxy routes: want 2, got %d", got)
 	}
 	for i, route := range conf.Routes.Proxy {
+		// Checked in TestHealth.
+		route.MinHealthyUpstreams = nil
 		if route != proxies[i] {
-			t.Errorf("proxy route %d: want %s, got %s", i, proxies[i], route)
+			t.Errorf("proxy route %d: want %v, got %v", i, proxies[i], route)
 		}
 	}
 }
 
+func TestHTTP3(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/config.yml")
+	if !conf.HTTP3.Enabled {
+		t.Error("http3: want enabled")
+	}
+	if conf.HTTP3.Addr != ":443" {
+		t.Errorf("http3 listen: want :443, got %s", conf.HTTP3.Addr)
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/config.yml")
+	auth := conf.TLS.ClientAuth
+	if auth == nil {
+		t.Fatal("client_auth: want non-nil")
+	}
+	if auth.CAFile != "ca.crt" {
+		t.Errorf("cafile: want ca.crt, got %s", auth.CAFile)
+	}
+	if auth.Mode.ClientAuthType != tls.RequireAndVerifyClientCert {
+		t.Errorf("mode: want require, got %v", auth.Mode)
+	}
+	if len(auth.Hosts) != 1 || auth.Hosts[0] != "internal.example.com" {
+		t.Errorf("hosts: want [internal.example.com], got %v", auth.Hosts)
+	}
+}
+
+func TestTLSPolicy(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/config.yml")
+	if conf.TLS.Preset != "intermediate" {
+		t.Errorf("preset: want intermediate, got %s", conf.TLS.Preset)
+	}
+	if got := conf.TLS.MinVersion.Version; got != tls.VersionTLS13 {
+		t.Errorf("min_version: want TLS 1.3, got %s", tls.VersionName(got))
+	}
+	suites := conf.TLS.CipherSuites
+	if len(suites) != 1 || suites[0].ID != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("cipher_suites: want [TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256], got %v", suites)
+	}
+	if curves := conf.TLS.Curves; len(curves) != 2 || curves[0].ID != tls.X25519MLKEM768 || curves[1].ID != tls.X25519 {
+		t.Errorf("curves: want [X25519MLKEM768 X25519], got %v", curves)
+	}
+	if alpn := conf.TLS.ALPN; len(alpn) != 1 || alpn[0] != "http/1.1" {
+		t.Errorf("alpn: want [http/1.1], got %v", alpn)
+	}
+	if got := conf.TLS.SessionTicketRotation; got != 12*time.Hour {
+		t.Errorf("session_ticket_rotation: want 12h, got %v", got)
+	}
+}
+
+func TestInsecureCipherSuite(t *testing.T) {
+	var suite config.CipherSuite
+	if err := suite.UnmarshalText([]byte("TLS_RSA_WITH_RC4_128_SHA")); err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestClientAuthMode(t *testing.T) {
+	var mode config.ClientAuthMode
+	if err := mode.UnmarshalText([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	if mode.ClientAuthType != tls.VerifyClientCertIfGiven {
+		t.Errorf("request: want VerifyClientCertIfGiven, got %v", mode)
+	}
+	if err := mode.UnmarshalText([]byte("sometimes")); err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestListeners(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/listeners.yml")
+	want := []config.Listener{
+		{Addr: "unix:/run/legion.sock", Mode: config.FileMode{FileMode: 0660}, Owner: "www-data", Group: "www-data"},
+		{Addr: ":8443", MaxConns: 100, ProxyProtocol: &config.ProxyProtocol{Trusted: []config.CIDR{
+			{Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+			{Prefix: netip.MustParsePrefix("192.0.2.1/32")},
+		}}},
+	}
+	if got := conf.AllListeners(); !reflect.DeepEqual(got, want) {
+		t.Errorf("listeners: want %v, got %v", want, got)
+	}
+}
+
+func TestDefaultListener(t *testing.T) {
+	conf := newConf(t)
+	want := []config.Listener{{Addr: ":8000"}}
+	if got := conf.AllListeners(); len(got) != 1 || got[0] != want[0] {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestUnixListenFlag(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/listeners.yml", "-listen", "unix:/tmp/legion.sock")
+	want := []config.Listener{{Addr: "unix:/tmp/legion.sock"}}
+	if got := conf.AllListeners(); len(got) != 1 || got[0] != want[0] {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestInvalidFileMode(t *testing.T) {
+	var mode config.FileMode
+	if err := mode.UnmarshalText([]byte("0999")); err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/config.yml")
+	want := config.Timeouts{ReadHeader: 5 * time.Second, Idle: 2 * time.Minute, Shutdown: 30 * time.Second}
+	if conf.Timeouts != want {
+		t.Errorf("timeouts: want %v, got %v", want, conf.Timeouts)
+	}
+	if conf.MaxHeaderBytes != 65536 {
+		t.Errorf("max header bytes: want 65536, got %d", conf.MaxHeaderBytes)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/config.yml")
+	want := config.Limits{MaxConns: 1000, MaxConnsPerIP: 20}
+	if conf.Limits != want {
+		t.Errorf("limits: want %v, got %v", want, conf.Limits)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/config.yml")
+	want := []config.CIDR{
+		{Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+		{Prefix: netip.MustParsePrefix("2001:db8::/32")},
+	}
+	if !reflect.DeepEqual(conf.TrustedProxies, want) {
+		t.Errorf("trusted proxies: want %v, got %v", want, conf.TrustedProxies)
+	}
+}
+
+func TestLogFormat(t *testing.T) {
+	tests := []struct {
+		args      []string
+		app       config.LogFormat
+		accessLog string
+	}{
+		{nil, "logfmt", "logfmt"},
+		{[]string{"-config", "testdata/config.yml"}, "json", "json"},
+		{[]string{"-config", "testdata/listeners.yml"}, "logfmt", "combined"},
+	}
+	for _, test := range tests {
+		conf := newConf(t, test.args...)
+		if conf.Log.Format != test.app {
+			t.Errorf("%v: log format: want %s, got %s", test.args, test.app, conf.Log.Format)
+		}
+		if conf.AccessLog.Format != test.accessLog {
+			t.Errorf("%v: access log format: want %s, got %s", test.args, test.accessLog, conf.AccessLog.Format)
+		}
+	}
+}
+
+func TestAccessLogFields(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/listeners.yml")
+	want := []string{"status", "route"}
+	if !reflect.DeepEqual(conf.AccessLog.Fields, want) {
+		t.Errorf("fields: want %v, got %v", want, conf.AccessLog.Fields)
+	}
+}
+
+func TestLogFile(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/listeners.yml")
+	if conf.Log.File != "" {
+		t.Errorf("log file: want stdout, got %s", conf.Log.File)
+	}
+	if conf.AccessLog.File != "/var/log/legion/access.log" {
+		t.Errorf("access log file: want /var/log/legion/access.log, got %s", conf.AccessLog.File)
+	}
+	want := config.Rotate{MaxSize: 100 << 20, MaxBackups: 7, Compress: true}
+	if conf.AccessLog.Rotate != want {
+		t.Errorf("rotate: want %+v, got %+v", want, conf.AccessLog.Rotate)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/listeners.yml")
+	want := config.Admin{Addr: "127.0.0.1:9100", AllowRemote: true, Metrics: true, API: true}
+	if conf.Admin != want {
+		t.Errorf("admin: want %+v, got %+v", want, conf.Admin)
+	}
+	if conf := newConf(t); conf.Admin != (config.Admin{}) {
+		t.Errorf("admin: want disabled by default, got %+v", conf.Admin)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/config.yml")
+	want := config.Health{Enabled: true, Liveness: "/healthz", Readiness: "/ready", MinHealthyUpstreams: 1, UpstreamRecovery: time.Minute}
+	if conf.Health != want {
+		t.Errorf("health: want %+v, got %+v", want, conf.Health)
+	}
+	if min := conf.Routes.Proxy[1].MinHealthyUpstreams; min == nil || *min != 0 {
+		t.Errorf("route min_healthy_upstreams: want 0, got %v", min)
+	}
+	if min := conf.Routes.Proxy[0].MinHealthyUpstreams; min != nil {
+		t.Errorf("route min_healthy_upstreams: want unset, got %d", *min)
+	}
+	conf = newConf(t)
+	if conf.Health.Enabled || conf.Health.Liveness != "/healthz" || conf.Health.Readiness != "/readyz" || conf.Health.UpstreamRecovery != 30*time.Second {
+		t.Errorf("health: want disabled with defaults, got %+v", conf.Health)
+	}
+}
+
+func TestDump(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/listeners.yml")
+	if want := (config.Dump{Enabled: true, BodyBytes: 4096}); conf.Dump != want {
+		t.Errorf("dump: want %+v, got %+v", want, conf.Dump)
+	}
+	route := conf.Routes.Proxy[0]
+	if want := (config.Dump{Enabled: true, ShowSecrets: true}); route.Dump == nil || *route.Dump != want {
+		t.Errorf("route dump: want %+v, got %+v", want, route.Dump)
+	}
+	if conf := newConf(t, "-config", "testdata/config.yml"); conf.Dump.Enabled || conf.Routes.Proxy[0].Dump != nil {
+		t.Errorf("dump: want disabled by default, got %+v", conf.Dump)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/config.yml")
+	tracing := conf.Tracing
+	if !tracing.Enabled || tracing.Protocol != "http" || tracing.Endpoint != "http://collector:4318" {
+		t.Errorf("tracing: got %+v", tracing)
+	}
+	if tracing.ServiceName != "legion" {
+		t.Errorf("service name: want legion, got %s", tracing.ServiceName)
+	}
+	if *tracing.SampleRatio != 0.1 {
+		t.Errorf("sample ratio: want 0.1, got %v", *tracing.SampleRatio)
+	}
+
+	conf = newConf(t, "-config", "testdata/listeners.yml")
+	if conf.Tracing.Enabled || conf.Tracing.Protocol != "grpc" || *conf.Tracing.SampleRatio != 1 {
+		t.Errorf("tracing: want disabled with defaults, got %+v", conf.Tracing)
+	}
+}
+
+func TestInvalidOTLPProtocol(t *testing.T) {
+	var protocol config.OTLPProtocol
+	if err := protocol.UnmarshalText([]byte("zipkin")); err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		text string
+		want config.ByteSize
+	}{
+		{"1024", 1024},
+		{"10K", 10 << 10},
+		{"10KB", 10 << 10},
+		{"100M", 100 << 20},
+		{"1g", 1 << 30},
+	}
+	for _, test := range tests {
+		var size config.ByteSize
+		if err := size.UnmarshalText([]byte(test.text)); err != nil {
+			t.Errorf("%s: %v", test.text, err)
+		} else if size != test.want {
+			t.Errorf("%s: want %d, got %d", test.text, test.want, size)
+		}
+	}
+	for _, text := range []string{"", "M", "-1K", "10X"} {
+		var size config.ByteSize
+		if err := size.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("%q: expect error", text)
+		}
+	}
+}
+
+func TestInvalidLogFormat(t *testing.T) {
+	var format config.LogFormat
+	if err := format.UnmarshalText([]byte("combined")); err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestDefaultShutdownTimeout(t *testing.T) {
+	conf := newConf(t)
+	if conf.Timeouts.Shutdown != 30*time.Second {
+		t.Errorf("shutdown timeout: want 30s, got %s", conf.Timeouts.Shutdown)
+	}
+}
+
+func TestReload(t *testing.T) {
+	conf := newConf(t, "-config", "testdata/listeners.yml")
+	if !conf.Reload.Watch {
+		t.Error("reload watch: want enabled")
+	}
+	if conf.Reload.Interval != 2*time.Second {
+		t.Errorf("reload interval: want 2s, got %s", conf.Reload.Interval)
+	}
+	if conf.File != "testdata/listeners.yml" {
+		t.Errorf("file: want testdata/listeners.yml, got %s", conf.File)
+	}
+}
+
+func TestCIDR(t *testing.T) {
+	tests := map[string]string{
+		"10.1.2.3/8":  "10.0.0.0/8",
+		"192.0.2.1":   "192.0.2.1/32",
+		"2001:db8::1": "2001:db8::1/128",
+	}
+	for input, want := range tests {
+		var cidr config.CIDR
+		if err := cidr.UnmarshalText([]byte(input)); err != nil {
+			t.Errorf("%s: %v", input, err)
+		} else if cidr.String() != want {
+			t.Errorf("%s: want %s, got %s", input, want, cidr)
+		}
+	}
+	var cidr config.CIDR
+	if err := cidr.UnmarshalText([]byte("10.0.0.0/33")); err == nil {
+		t.Error("expect error")
+	}
+}
+
 func TestOverrideAddress(t *testing.T) {
 	conf := newConf(t,
 		"-config", "testdata/config.yml",
@@ -137,7 +496,7 @@ func TestOverrideRoutes(t *testing.T) {
 	if got := len(conf.Routes.Static); got != 1 {
 		t.Errorf("want 1 static route, got %d", got)
 	}
-	want := config.StaticRoute{"/", "."}
+	want := config.StaticRoute{Source: "/", Target: "."}
 	if got := conf.Routes.Static[0]; got != want {
 		t.Errorf("route: want %v, got %v", want, got)
 	}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"crypto/tls"
 	"log/slog"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -17,6 +19,7 @@ func ReadFile(filename string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 	fileConf := &Config{}
 	err = yaml.NewDecoder(f).Decode(fileConf)
 	if err != nil {
@@ -26,7 +29,16 @@ func ReadFile(filename string) (*Config, error) {
 	if fileConf.Addr != "" {
 		conf.Addr = fileConf.Addr
 	}
+	conf.Listeners = fileConf.Listeners
 	conf.LogLevel = fileConf.LogLevel
+	if fileConf.Log.Format == "" {
+		fileConf.Log.Format = conf.Log.Format
+	}
+	conf.Log = fileConf.Log
+	conf.AccessLog = fileConf.AccessLog
+	if conf.AccessLog.Format == "" {
+		conf.AccessLog.Format = string(conf.Log.Format)
+	}
 
 	if len(fileConf.Routes.Static) > 0 {
 		conf.Routes.Static = fileConf.Routes.Static
@@ -36,14 +48,74 @@ func ReadFile(filename string) (*Config, error) {
 	}
 
 	conf.TLS = fileConf.TLS
+	conf.HTTP3 = fileConf.HTTP3
+	conf.H2C = fileConf.H2C
+	if auth := conf.TLS.ClientAuth; auth != nil && auth.Mode.ClientAuthType == tls.NoClientCert {
+		auth.Mode.ClientAuthType = tls.RequireAndVerifyClientCert
+	}
+
+	conf.Timeouts = fileConf.Timeouts
+	if conf.Timeouts.Shutdown == 0 {
+		conf.Timeouts.Shutdown = defaultShutdownTimeout
+	}
+	conf.MaxHeaderBytes = fileConf.MaxHeaderBytes
+	conf.Limits = fileConf.Limits
+	conf.TrustedProxies = fileConf.TrustedProxies
+	conf.Admin = fileConf.Admin
+	conf.Tracing = fileConf.Tracing
+	if conf.Tracing.Protocol == "" {
+		conf.Tracing.Protocol = "grpc"
+	}
+	if conf.Tracing.ServiceName == "" {
+		conf.Tracing.ServiceName = "legion"
+	}
+	if conf.Tracing.SampleRatio == nil {
+		conf.Tracing.SampleRatio = defaultConfig().Tracing.SampleRatio
+	}
+	conf.Dump = fileConf.Dump
+	conf.Health = fileConf.Health
+	if conf.Health.Liveness == "" {
+		conf.Health.Liveness = "/healthz"
+	}
+	if conf.Health.Readiness == "" {
+		conf.Health.Readiness = "/readyz"
+	}
+	if conf.Health.UpstreamRecovery == 0 {
+		conf.Health.UpstreamRecovery = defaultUpstreamRecovery
+	}
+
+	conf.Reload = fileConf.Reload
+	if conf.Reload.Interval == 0 {
+		conf.Reload.Interval = 2 * time.Second
+	}
+	conf.File = filename
 
 	return conf, nil
 }
 
+const (
+	defaultShutdownTimeout  = 30 * time.Second
+	defaultUpstreamRecovery = 30 * time.Second
+)
+
 func defaultConfig() *Config {
+	sampleRatio := 1.0
 	return &Config{
-		Addr:     ":8000",
-		LogLevel: LogLevel{slog.LevelInfo},
+		Addr:      ":8000",
+		LogLevel:  LogLevel{slog.LevelInfo},
+		Log:       Log{Format: "logfmt"},
+		AccessLog: AccessLog{Format: "logfmt"},
+		Timeouts:  Timeouts{Shutdown: defaultShutdownTimeout},
+		Tracing: Tracing{
+			Protocol:    "grpc",
+			ServiceName: "legion",
+			SampleRatio: &sampleRatio,
+		},
+		Health: Health{
+			Liveness:         "/healthz",
+			Readiness:        "/readyz",
+			UpstreamRecovery: defaultUpstreamRecovery,
+		},
 		Routes: Routes{
 			Static: []StaticRoute{{Source: "/", Target: "."}},
 		},
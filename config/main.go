@@ -3,6 +3,7 @@ package config
 import (
 	"flag"
 	"net"
+	"strings"
 )
 
 func ReadConfig(args []string) (*Config, error) {
@@ -11,8 +12,11 @@ func ReadConfig(args []string) (*Config, error) {
 	configFile := flags.String("config", "", "path to configuration file")
 
 	var addr *string
-	flags.Func("listen", "address to listen on (default :8000)", func(value string) error {
+	flags.Func("listen", "address to listen on, or unix:<path> (default :8000)", func(value string) error {
 		addr = &value
+		if strings.HasPrefix(value, "unix:") {
+			return nil
+		}
 		_, err := net.ResolveTCPAddr("tcp", value)
 		return err
 	})
@@ -59,6 +63,7 @@ incoming paths map to actual requests:
 
 	if addr != nil {
 		conf.Addr = *addr
+		conf.Listeners = nil
 	}
 	if level != nil {
 		conf.LogLevel = *level
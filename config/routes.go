@@ -15,10 +15,19 @@ func (r *Routes) Set(value string) error {
 	if !found {
 		return errors.New("missing '='")
 	}
-	if strings.HasPrefix(target, "http:") || strings.HasPrefix(target, "https:") {
-		r.Proxy = append(r.Proxy, ProxyRoute{source, target})
+	if isURL(target) {
+		r.Proxy = append(r.Proxy, ProxyRoute{Source: source, Target: target})
 	} else {
-		r.Static = append(r.Static, StaticRoute{source, target})
+		r.Static = append(r.Static, StaticRoute{Source: source, Target: target})
 	}
 	return nil
 }
+
+func isURL(target string) bool {
+	for _, scheme := range []string{"http:", "https:", "h2c:", "unix:", "http+unix:"} {
+		if strings.HasPrefix(target, scheme) {
+			return true
+		}
+	}
+	return false
+}
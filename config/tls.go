@@ -0,0 +1,111 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+type ClientAuthMode struct {
+	tls.ClientAuthType
+}
+
+func (m *ClientAuthMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "request":
+		m.ClientAuthType = tls.VerifyClientCertIfGiven
+	case "require":
+		m.ClientAuthType = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("%s: invalid client auth mode", text)
+	}
+	return nil
+}
+
+func (m ClientAuthMode) MarshalText() ([]byte, error) {
+	switch m.ClientAuthType {
+	case tls.VerifyClientCertIfGiven:
+		return []byte("request"), nil
+	case tls.RequireAndVerifyClientCert:
+		return []byte("require"), nil
+	}
+	return nil, nil
+}
+
+type TLSVersion struct {
+	Version uint16
+}
+
+func (v *TLSVersion) UnmarshalText(text []byte) error {
+	switch strings.TrimPrefix(string(text), "TLS") {
+	case "1.0":
+		v.Version = tls.VersionTLS10
+	case "1.1":
+		v.Version = tls.VersionTLS11
+	case "1.2":
+		v.Version = tls.VersionTLS12
+	case "1.3":
+		v.Version = tls.VersionTLS13
+	default:
+		return fmt.Errorf("%s: invalid TLS version", text)
+	}
+	return nil
+}
+
+func (v TLSVersion) MarshalText() ([]byte, error) {
+	if v.Version == 0 {
+		return nil, nil
+	}
+	return []byte(strings.TrimPrefix(tls.VersionName(v.Version), "TLS ")), nil
+}
+
+type CipherSuite struct {
+	ID uint16
+}
+
+// UnmarshalText accepts cipher suite names as defined in crypto/tls, e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Insecure cipher suites are
+// rejected.
+func (c *CipherSuite) UnmarshalText(text []byte) error {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == string(text) {
+			c.ID = suite.ID
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: unknown or insecure cipher suite", text)
+}
+
+func (c CipherSuite) MarshalText() ([]byte, error) {
+	return []byte(tls.CipherSuiteName(c.ID)), nil
+}
+
+type Curve struct {
+	ID tls.CurveID
+}
+
+var curves = map[string]tls.CurveID{
+	"X25519MLKEM768": tls.X25519MLKEM768,
+	"X25519":         tls.X25519,
+	"P-256":          tls.CurveP256,
+	"P-384":          tls.CurveP384,
+	"P-521":          tls.CurveP521,
+}
+
+func (c *Curve) UnmarshalText(text []byte) error {
+	id, ok := curves[string(text)]
+	if !ok {
+		return fmt.Errorf("%s: unknown curve", text)
+	}
+	c.ID = id
+	return nil
+}
+
+func (c Curve) MarshalText() ([]byte, error) {
+	for name, id := range curves {
+		if id == c.ID {
+			return []byte(name), nil
+		}
+	}
+	return []byte(c.ID.String()), nil
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+func requireClientCert(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert := verifiedCert(r)
+		if cert == nil || !matchCert(pattern, cert) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifiedCert returns the leaf certificate of a client that successfully
+// authenticated itself, or nil if the client did not present a certificate.
+func verifiedCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+func matchCert(pattern string, cert *x509.Certificate) bool {
+	for _, name := range certNames(cert) {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func certNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.String()}
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// forwardedClientCert formats cert in the X-Forwarded-Client-Cert format
+// used by Envoy.
+func forwardedClientCert(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	fields := []string{
+		"Hash=" + hex.EncodeToString(hash[:]),
+		"Subject=" + strconv.Quote(cert.Subject.String()),
+	}
+	for _, uri := range cert.URIs {
+		fields = append(fields, "URI="+uri.String())
+	}
+	for _, name := range cert.DNSNames {
+		fields = append(fields, "DNS="+name)
+	}
+	return strings.Join(fields, ";")
+}
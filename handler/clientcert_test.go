@@ -0,0 +1,117 @@
+package handler_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akojo/legion/handler"
+)
+
+func TestRequireClientCert(t *testing.T) {
+	h := handler.New()
+	if err := h.FileServer("/", "testdata/html", handler.RequireClientCert("*.example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		name string
+		cert *x509.Certificate
+		want int
+	}
+	tests := []test{
+		{"no certificate", nil, 403},
+		{"matching SAN", makeClientCert(t, "client", "api.example.com"), 200},
+		{"mismatching SAN", makeClientCert(t, "client", "api.example.org"), 403},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "https://example.com/", nil)
+		if tc.cert != nil {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{tc.cert}}
+		}
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+
+		if got := resp.Result().StatusCode; got != tc.want {
+			t.Errorf("%s: want %d, got %d", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestInvalidClientCertPattern(t *testing.T) {
+	h := handler.New()
+	err := h.FileServer("/", "testdata/html", handler.RequireClientCert("[invalid"))
+	if err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestForwardedClientCert(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := `Subject="CN=client";DNS=client.example.com`
+		if got := r.Header.Get("X-Forwarded-Client-Cert"); !strings.Contains(got, want) {
+			t.Errorf("expect %#v to contain %#v", got, want)
+		}
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	h := makeReverseProxy(t, "/", server.URL)
+
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{makeClientCert(t, "client", "client.example.com")}}
+	resp := httptest.NewRecorder()
+
+	h.ServeHTTP(resp, req)
+}
+
+func TestStripForwardedClientCert(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Forwarded-Client-Cert"); got != "" {
+			t.Errorf("X-Forwarded-Client-Cert: want empty, got %#v", got)
+		}
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	h := makeReverseProxy(t, "/", server.URL)
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("X-Forwarded-Client-Cert", `Subject="CN=admin"`)
+	resp := httptest.NewRecorder()
+
+	h.ServeHTTP(resp, req)
+}
+
+func makeClientCert(t *testing.T, cn string, dnsNames ...string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
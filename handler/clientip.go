@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/akojo/legion/logger"
+)
+
+type clientKey struct{}
+
+// client holds client address resolved by TrustProxies.
+type client struct {
+	addr netip.Addr
+	// Direct peer is a trusted proxy whose forwarding headers are honored.
+	trusted bool
+}
+
+// TrustProxies honors forwarding headers of requests only when direct peer
+// address is in trusted. Client IP address is resolved from X-Forwarded-For
+// as the last address not in trusted.
+func TrustProxies(trusted []netip.Prefix, next http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := client{addr: peerAddr(r)}
+		if c.addr.IsValid() && isTrusted(c.addr) {
+			c.trusted = true
+			forwarded := forwardedFor(r.Header)
+			for i := len(forwarded) - 1; i >= 0; i-- {
+				addr, err := netip.ParseAddr(forwarded[i])
+				if err != nil {
+					break
+				}
+				c.addr = addr.Unmap()
+				if !isTrusted(c.addr) {
+					break
+				}
+			}
+		}
+		if c.addr.IsValid() {
+			logger.AddAttrs(r.Context(), slog.String("client_ip", c.addr.String()))
+		}
+		ctx := context.WithValue(r.Context(), clientKey{}, c)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP returns client IP address of request as resolved by
+// TrustProxies. Without TrustProxies, or for clients connected over a Unix
+// domain socket, address of direct peer is returned, which may be invalid.
+func ClientIP(r *http.Request) netip.Addr {
+	return clientOf(r).addr
+}
+
+func clientOf(r *http.Request) client {
+	if c, ok := r.Context().Value(clientKey{}).(client); ok {
+		return c
+	}
+	return client{addr: peerAddr(r)}
+}
+
+func peerAddr(r *http.Request) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
+
+// forwardedFor returns addresses in all X-Forwarded-For headers.
+func forwardedFor(header http.Header) []string {
+	var addrs []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(value, ",") {
+			addrs = append(addrs, strings.TrimSpace(addr))
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+)
+
+// Headers redacted from dumps unless secrets are shown.
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// dumper logs requests and responses of a route with headers and optionally
+// bodies, for debugging. Dumps with bodies are logged once the body has been
+// read, so for streaming requests they may appear after the response.
+type dumper struct {
+	source      string
+	maxBody     int64
+	showSecrets bool
+}
+
+func newDumper(source string, rt route) *dumper {
+	if !rt.dump {
+		return nil
+	}
+	return &dumper{source: source, maxBody: rt.dumpBody, showSecrets: rt.dumpSecrets}
+}
+
+// inbound dumps requests received from clients and responses sent to them.
+func (d *dumper) inbound(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := *r
+		req.Header = d.redact(r.Header)
+		head, _ := httputil.DumpRequest(&req, false)
+		r = r.WithContext(r.Context())
+		finish := d.capture(r.Context(), &r.Body, "inbound request", head)
+
+		cw := &captureWriter{ResponseWriter: w, status: http.StatusOK, max: d.maxBody}
+		next.ServeHTTP(cw, r)
+		finish()
+
+		header := cw.header
+		if header == nil {
+			header = w.Header()
+		}
+		// Written as is, since DumpResponse would rewrite Content-Length
+		// and other framing headers.
+		var resp bytes.Buffer
+		fmt.Fprintf(&resp, "%s %d %s\r\n", r.Proto, cw.status, http.StatusText(cw.status))
+		d.redact(header).Write(&resp)
+		resp.WriteString("\r\n")
+		d.log(r.Context(), "inbound response", resp.Bytes(), cw.body.Bytes(), cw.bytes)
+	})
+}
+
+// transport dumps requests sent to upstream and responses received from it.
+func (d *dumper) transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		out := *r
+		out.Header = d.redact(r.Header)
+		head, _ := httputil.DumpRequestOut(&out, false)
+		r = r.WithContext(r.Context())
+		d.capture(r.Context(), &r.Body, "outbound request", head)
+
+		resp, err := next.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		in := *resp
+		in.Header = d.redact(resp.Header)
+		head, _ = httputil.DumpResponse(&in, false)
+		d.capture(r.Context(), &resp.Body, "outbound response", head)
+		return resp, nil
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// capture logs message with head and up to maxBody bytes of body, which is
+// replaced with a reader capturing it. Message is logged once body has been
+// read to the end or closed, or when returned function is called, or right
+// away if there is no body to capture.
+func (d *dumper) capture(ctx context.Context, body *io.ReadCloser, msg string, head []byte) func() {
+	if d.maxBody == 0 || *body == nil || *body == http.NoBody {
+		d.log(ctx, msg, head, nil, 0)
+		return func() {}
+	}
+	c := &bodyCapture{ReadCloser: *body, max: d.maxBody}
+	c.done = func(captured []byte, n int64) {
+		d.log(ctx, msg, head, captured, n)
+	}
+	*body = c
+	return c.finish
+}
+
+func (d *dumper) log(ctx context.Context, msg string, head, body []byte, n int64) {
+	var dump strings.Builder
+	dump.WriteString(strings.ReplaceAll(string(head), "\r\n", "\n"))
+	dump.Write(body)
+	if n > int64(len(body)) {
+		fmt.Fprintf(&dump, "\n[%d more bytes]", n-int64(len(body)))
+	}
+	attrs := []slog.Attr{slog.String("route", d.source)}
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	attrs = append(attrs, slog.String("dump", dump.String()))
+	slog.LogAttrs(ctx, slog.LevelInfo, msg, attrs...)
+}
+
+// redact returns a copy of header with secrets replaced, unless they are
+// shown.
+func (d *dumper) redact(header http.Header) http.Header {
+	if d.showSecrets {
+		return header
+	}
+	header = header.Clone()
+	for _, name := range secretHeaders {
+		if values := header[name]; len(values) > 0 {
+			header[name] = []string{"[REDACTED]"}
+		}
+	}
+	return header
+}
+
+// bodyCapture records up to max bytes of a body as it is read, and passes
+// them to done along with total number of bytes read once body has been
+// read to the end or closed. Body may be read and closed by different
+// goroutines.
+type bodyCapture struct {
+	io.ReadCloser
+	max  int64
+	done func(captured []byte, n int64)
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	n        int64
+	finished bool
+}
+
+func (c *bodyCapture) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.mu.Lock()
+	c.n += int64(n)
+	if room := c.max - int64(c.buf.Len()); room > 0 {
+		c.buf.Write(p[:min(int64(n), room)])
+	}
+	c.mu.Unlock()
+	if err == io.EOF {
+		c.finish()
+	}
+	return n, err
+}
+
+func (c *bodyCapture) Close() error {
+	err := c.ReadCloser.Close()
+	c.finish()
+	return err
+}
+
+// finish calls done unless already called. Once finish returns, done has
+// returned as well.
+func (c *bodyCapture) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished {
+		return
+	}
+	c.finished = true
+	c.done(bytes.Clone(c.buf.Bytes()), c.n)
+}
+
+// captureWriter records status, headers and up to max bytes of body of a
+// response.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	max    int64
+	body   bytes.Buffer
+	bytes  int64
+}
+
+func (w *captureWriter) WriteHeader(code int) {
+	if w.header == nil && code >= 200 {
+		w.status = code
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if w.header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	if room := w.max - int64(w.body.Len()); room > 0 {
+		w.body.Write(b[:min(int64(n), room)])
+	}
+	return n, err
+}
+
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
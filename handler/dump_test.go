@@ -0,0 +1,100 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akojo/legion/handler"
+)
+
+func TestDump(t *testing.T) {
+	dumps := dumpRoute(t, handler.Dump(5, false))
+	for _, msg := range []string{"inbound request", "outbound request", "outbound response", "inbound response"} {
+		dump, ok := dumps[msg]
+		if !ok {
+			t.Errorf("%s: not dumped, got %v", msg, dumps)
+			continue
+		}
+		if strings.Contains(dump, "secret") {
+			t.Errorf("%s: secrets not redacted: %s", msg, dump)
+		}
+	}
+	if dump := dumps["inbound request"]; !strings.HasPrefix(dump, "POST /api/echo HTTP/1.1\n") || !strings.Contains(dump, "Authorization: [REDACTED]") {
+		t.Errorf("inbound request: got %s", dump)
+	}
+	if dump := dumps["outbound request"]; !strings.HasPrefix(dump, "POST /echo HTTP/1.1\n") || !strings.Contains(dump, "X-Forwarded-For: 127.0.0.1") {
+		t.Errorf("outbound request: want rewritten request, got %s", dump)
+	}
+	if dump := dumps["outbound request"]; !strings.HasSuffix(dump, "\nhello\n[6 more bytes]") {
+		t.Errorf("outbound request: want truncated body, got %s", dump)
+	}
+	if dump := dumps["inbound response"]; !strings.HasPrefix(dump, "HTTP/1.1 201 Created\n") || !strings.Contains(dump, "Set-Cookie: [REDACTED]") {
+		t.Errorf("inbound response: got %s", dump)
+	}
+}
+
+func TestDumpShowSecrets(t *testing.T) {
+	dumps := dumpRoute(t, handler.Dump(0, true))
+	if dump := dumps["outbound request"]; !strings.Contains(dump, "Cookie: session=secret") {
+		t.Errorf("outbound request: want cookie, got %s", dump)
+	}
+	if dump := dumps["outbound response"]; strings.Contains(dump, "hello") {
+		t.Errorf("outbound response: want no body, got %s", dump)
+	}
+}
+
+// dumpRoute sends a request with credentials and a body to a proxy route on
+// /api/ with dump option opt, and returns logged dumps by message.
+func dumpRoute(t *testing.T, opt handler.Option) map[string]string {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.WriteHeader(http.StatusCreated)
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(r.Body)
+		w.Write(buf.Bytes())
+	}))
+	defer upstream.Close()
+	h := handler.New()
+	if err := h.ReverseProxy("/api/", upstream.URL, opt); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/api/echo", strings.NewReader("hello world"))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	server.Close()
+
+	dumps := map[string]string{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry struct {
+			Msg   string `json:"msg"`
+			Route string `json:"route"`
+			Dump  string `json:"dump"`
+		}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Dump != "" && entry.Route == "/api/" {
+			dumps[entry.Msg] = entry.Dump
+		}
+	}
+	return dumps
+}
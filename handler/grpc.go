@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes, see
+// https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcDeadlineExceeded = 4
+	grpcUnavailable      = 14
+)
+
+// grpcError reports upstream failure as a trailers-only gRPC response.
+func grpcError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.Warn("gRPC upstream error", "error", err, "path", r.URL.Path)
+
+	code := grpcUnavailable
+	if errors.Is(err, context.DeadlineExceeded) {
+		code = grpcDeadlineExceeded
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", grpcMessage(err.Error()))
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcMessage percent-encodes msg as required for grpc-message header.
+func grpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package handler_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akojo/legion/handler"
+)
+
+func TestGRPCTrailers(t *testing.T) {
+	upstream := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("upstream proto: want HTTP/2, got %s", r.Proto)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte("message"))
+		w.(http.Flusher).Flush()
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer upstream.Close()
+
+	h := handler.New()
+	if err := h.ReverseProxy("/", upstream.URL, handler.GRPC()); err != nil {
+		t.Fatal(err)
+	}
+	proxy := newH2CServer(h)
+	defer proxy.Close()
+
+	resp := postGRPC(t, proxy.URL)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "message" {
+		t.Errorf("body: want 'message', got %#v", string(body))
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("grpc-status trailer: want 0, got %#v", got)
+	}
+}
+
+func TestGRPCUpstreamUnavailable(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	h := handler.New()
+	if err := h.ReverseProxy("/", upstream.URL, handler.GRPC()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/pets.Pets/Get", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+
+	if got := resp.Code; got != 200 {
+		t.Errorf("status: want 200, got %d", got)
+	}
+	if got := resp.Header().Get("Content-Type"); got != "application/grpc" {
+		t.Errorf("content-type: want application/grpc, got %#v", got)
+	}
+	if got := resp.Header().Get("Grpc-Status"); got != "14" {
+		t.Errorf("grpc-status: want 14, got %#v", got)
+	}
+	if got := resp.Header().Get("Grpc-Message"); got == "" {
+		t.Error("grpc-message: want non-empty")
+	}
+}
+
+func newH2CServer(h http.Handler) *httptest.Server {
+	server := httptest.NewUnstartedServer(h)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	return server
+}
+
+func postGRPC(t *testing.T, URL string) *http.Response {
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequest("POST", URL+"/pets.Pets/Get", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
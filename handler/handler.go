@@ -7,7 +7,10 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"path"
 	"strings"
+
+	"github.com/akojo/legion/tracing"
 )
 
 type Handler struct {
@@ -18,29 +21,72 @@ func New() *Handler {
 	return &Handler{ServeMux: http.NewServeMux()}
 }
 
-func (h *Handler) FileServer(source, dirname string) error {
+func (h *Handler) FileServer(source, dirname string, opts ...Option) error {
 	dirname, err := ensureDir(dirname)
 	if err != nil {
 		return err
 	}
-	return h.addHandler(source, http.FileServer(http.Dir(dirname)))
+	return h.addHandler(source, http.FileServer(http.Dir(dirname)), newRoute(opts))
 }
 
-func (h *Handler) ReverseProxy(source, URL string) error {
-	target, err := url.Parse(URL)
+func (h *Handler) ReverseProxy(source, URL string, opts ...Option) error {
+	target, socket, err := parseTarget(URL)
 	if err != nil {
 		return err
 	}
 	target.Path = strings.TrimRight(target.EscapedPath(), "/")
 
-	handler := &httputil.ReverseProxy{
+	rt := newRoute(opts)
+	h2c := target.Scheme == "h2c" || (rt.grpc && target.Scheme == "http")
+	if h2c {
+		target.Scheme = "http"
+	}
+	switch {
+	case rt.proxyProtocol == 0:
+	case rt.proxyProtocol != 1 && rt.proxyProtocol != 2:
+		return fmt.Errorf("%s: unsupported PROXY protocol version %d", source, rt.proxyProtocol)
+	case h2c || rt.grpc:
+		return fmt.Errorf("%s: PROXY protocol is not supported with HTTP/2 upstreams", source)
+	}
+	transport := newTransport(socket, h2c, rt.grpc, rt.proxyProtocol)
+	if d := newDumper(source, rt); d != nil {
+		transport = d.transport(transport)
+	}
+	if rt.har != nil {
+		transport = rt.har.transport(transport)
+	}
+	var up *upstream
+	if rt.upstreams != nil {
+		up = rt.upstreams.get(URL)
+	}
+
+	proxy := &httputil.ReverseProxy{
 		Rewrite: func(r *httputil.ProxyRequest) {
 			setURL(r.Out.URL, target)
 			setHeaders(r)
 		},
-		Transport: http.DefaultTransport,
+		Transport: &upstreamTransport{RoundTripper: tracing.Transport(transport), target: URL, upstream: up},
+		ModifyResponse: func(resp *http.Response) error {
+			// Request ID set by AssignRequestIDs takes precedence over one
+			// returned by upstream.
+			if RequestID(resp.Request) != "" {
+				resp.Header.Del(RequestIDHeader)
+			}
+			return nil
+		},
+	}
+	if rt.grpc {
+		proxy.FlushInterval = -1
+		proxy.ErrorHandler = grpcError
 	}
-	return h.addHandler(source, handler)
+	var handler http.Handler = proxy
+	if rt.proxyProtocol != 0 {
+		handler = clientAddresses(handler)
+	}
+	if up != nil {
+		handler = up.serve(handler)
+	}
+	return h.addHandler(source, handler, rt)
 }
 
 func setURL(u *url.URL, target *url.URL) {
@@ -50,10 +96,13 @@ func setURL(u *url.URL, target *url.URL) {
 }
 
 func setHeaders(r *httputil.ProxyRequest) {
+	// Forwarding headers are only honored from trusted proxies.
+	trusted := clientOf(r.In).trusted
+
 	clientIP, _, err := net.SplitHostPort(r.In.RemoteAddr)
 	if err == nil {
 		prior := r.In.Header["X-Forwarded-For"]
-		if len(prior) > 0 {
+		if trusted && len(prior) > 0 {
 			clientIP = strings.Join(prior, ", ") + ", " + clientIP
 		}
 		r.Out.Header.Set("X-Forwarded-For", clientIP)
@@ -62,30 +111,62 @@ func setHeaders(r *httputil.ProxyRequest) {
 	}
 
 	host := r.In.Header.Get("X-Forwarded-Host")
-	if len(host) > 0 {
+	if trusted && len(host) > 0 {
 		r.Out.Host = host
 	} else {
 		r.Out.Host = r.In.Host
 	}
 
 	proto := r.In.Header.Get("X-Forwarded-Proto")
-	if len(proto) > 0 {
+	if trusted && len(proto) > 0 {
 		r.Out.Header.Set("X-Forwarded-Proto", proto)
 	} else if r.In.TLS == nil {
 		r.Out.Header.Set("X-Forwarded-Proto", "http")
 	} else {
 		r.Out.Header.Set("X-Forwarded-Proto", "https")
 	}
+
+	if id := RequestID(r.In); id != "" {
+		r.Out.Header.Set(RequestIDHeader, id)
+	}
+
+	r.Out.Header.Del("X-Forwarded-Client-Cert")
+	if cert := verifiedCert(r.In); cert != nil {
+		r.Out.Header.Set("X-Forwarded-Client-Cert", forwardedClientCert(cert))
+	}
 }
 
-func (h *Handler) addHandler(source string, handler http.Handler) error {
+func (h *Handler) addHandler(source string, handler http.Handler, rt route) error {
 	pathStart := strings.Index(source, "/")
 	if pathStart < 0 {
 		return fmt.Errorf("%s: source path must start with '/'", source)
 	}
+	if rt.maxConcurrent > 0 {
+		lim := newLimiter(rt.maxConcurrent, rt.queue, rt.queueTimeout)
+		if rt.limiters != nil {
+			lim = rt.limiters.get(source, rt.maxConcurrent, rt.queue, rt.queueTimeout)
+		}
+		handler = lim.serve(handler)
+	}
+	if rt.clientCert != "" {
+		if _, err := path.Match(rt.clientCert, ""); err != nil {
+			return fmt.Errorf("%s: invalid client certificate pattern: %w", source, err)
+		}
+		handler = requireClientCert(rt.clientCert, handler)
+	}
+	if rt.readTimeout > 0 || rt.writeTimeout > 0 {
+		handler = withDeadlines(rt.readTimeout, rt.writeTimeout, handler)
+	}
 	pattern := strings.TrimRight(source, "/") + "/"
 	prefix := strings.TrimRight(source[pathStart:], "/")
-	h.Handle(pattern, http.StripPrefix(prefix, handler))
+	handler = http.StripPrefix(prefix, handler)
+	if d := newDumper(source, rt); d != nil {
+		handler = d.inbound(handler)
+	}
+	if rt.har != nil {
+		handler = rt.har.serve(source, handler)
+	}
+	h.Handle(pattern, logRoute(source, handler))
 	return nil
 }
 
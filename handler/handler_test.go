@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"strings"
 	"testing"
 
@@ -129,6 +130,22 @@ func TestProxy(t *testing.T) {
 	}
 }
 
+func TestH2CProxy(t *testing.T) {
+	server := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("proto: want HTTP/2, got %s", r.Proto)
+		}
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	target := strings.Replace(server.URL, "http:", "h2c:", 1)
+	resp := GET(makeReverseProxy(t, "/", target), "/")
+	if status := resp.Result().StatusCode; status != 204 {
+		t.Errorf("want 204, got %d", status)
+	}
+}
+
 func TestProxyHeaders(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		host := "host.example.com"
@@ -173,7 +190,7 @@ func TestForwardingForHeader(t *testing.T) {
 	}))
 	defer server.Close()
 
-	h := makeReverseProxy(t, "/", server.URL)
+	h := trustProxies(makeReverseProxy(t, "/", server.URL))
 
 	req := httptest.NewRequest("GET", "http://example.com/", nil)
 	req.Header.Set("X-Forwarded-For", "1.1.1.1")
@@ -191,7 +208,7 @@ func TestForwardingProtoHeader(t *testing.T) {
 	}))
 	defer server.Close()
 
-	h := makeReverseProxy(t, "/", server.URL)
+	h := trustProxies(makeReverseProxy(t, "/", server.URL))
 
 	req := httptest.NewRequest("GET", "http://example.com/", nil)
 	req.Header.Set("X-Forwarded-Proto", "https")
@@ -210,13 +227,76 @@ func TestForwardingHostHeader(t *testing.T) {
 	}))
 	defer server.Close()
 
-	h := makeReverseProxy(t, "/", server.URL)
+	h := trustProxies(makeReverseProxy(t, "/", server.URL))
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("X-Forwarded-Host", "forwarded.example.com")
+	resp := httptest.NewRecorder()
+
+	h.ServeHTTP(resp, req)
+}
+
+func TestUntrustedForwardingHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Forwarded-For"); got != "192.0.2.1" {
+			t.Errorf("X-Forwarded-For: want '192.0.2.1', got %#v", got)
+		}
+		if got := r.Header.Get("X-Forwarded-Proto"); got != "http" {
+			t.Errorf("X-Forwarded-Proto: want 'http', got %#v", got)
+		}
+		if got := r.Header.Get("X-Forwarded-Host"); got != "" {
+			t.Errorf("X-Forwarded-Host: want none, got %#v", got)
+		}
+		if got := r.Host; got != "example.com" {
+			t.Errorf("Host: want 'example.com', got %#v", got)
+		}
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	h := handler.TrustProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, makeReverseProxy(t, "/", server.URL))
 
 	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
 	req.Header.Set("X-Forwarded-Host", "forwarded.example.com")
 	resp := httptest.NewRecorder()
 
 	h.ServeHTTP(resp, req)
+	if got := resp.Result().StatusCode; got != 204 {
+		t.Errorf("want 204, got %d", got)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		{"192.0.2.1:1234", []string{"1.1.1.1"}, "1.1.1.1"},
+		{"192.0.2.1:1234", []string{"1.1.1.1, 192.0.2.2"}, "1.1.1.1"},
+		{"192.0.2.1:1234", []string{"8.8.8.8, 1.1.1.1", "192.0.2.2"}, "1.1.1.1"},
+		{"192.0.2.1:1234", []string{"192.0.2.3, 192.0.2.2"}, "192.0.2.3"},
+		{"192.0.2.1:1234", []string{"1.1.1.1, garbage"}, "192.0.2.1"},
+		{"198.51.100.1:1234", []string{"1.1.1.1"}, "198.51.100.1"},
+	}
+	for _, test := range tests {
+		var got netip.Addr
+		h := trustProxies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = handler.ClientIP(r)
+		}))
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RemoteAddr = test.remote
+		for _, value := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got.String() != test.want {
+			t.Errorf("%s %v: want %s, got %s", test.remote, test.forwarded, test.want, got)
+		}
+	}
 }
 
 func TestProxyRewrites(t *testing.T) {
@@ -303,6 +383,12 @@ func makeReverseProxy(t *testing.T, source, URL string) http.Handler {
 	return h
 }
 
+// trustProxies trusts forwarding headers from addresses used by
+// httptest.NewRequest.
+func trustProxies(h http.Handler) http.Handler {
+	return handler.TrustProxies([]netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, h)
+}
+
 func GET(h http.Handler, path string) *httptest.ResponseRecorder {
 	req := httptest.NewRequest("GET", path, nil)
 	resp := httptest.NewRecorder()
@@ -0,0 +1,442 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/akojo/legion/har"
+)
+
+// Defaults for HAR capture options.
+const (
+	defaultHARMaxBody    = 64 << 10
+	defaultHARMaxEntries = 1000
+)
+
+// HARCapture selects requests to record in a HAR capture and limits its
+// size.
+type HARCapture struct {
+	// Sources of routes to record, all if empty.
+	Routes []string `json:"routes,omitempty"`
+	// Pattern of request paths to record, as in path.Match. All paths if
+	// empty.
+	Path string `json:"path,omitempty"`
+	// Bodies are recorded up to MaxBodyBytes, 64 KiB if zero and not at all
+	// if negative.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// Requests after MaxEntries are not recorded, 1000 if zero.
+	MaxEntries int `json:"max_entries"`
+	// Record Authorization and cookie headers, which are redacted by
+	// default.
+	ShowSecrets bool `json:"show_secrets"`
+}
+
+// HARStatus describes state of HAR capture.
+type HARStatus struct {
+	Capturing bool        `json:"capturing"`
+	Started   *time.Time  `json:"started,omitempty"`
+	Entries   int         `json:"entries"`
+	Dropped   int         `json:"dropped"`
+	Capture   *HARCapture `json:"capture,omitempty"`
+}
+
+// HARRecorder records requests on proxy routes as HTTP Archive entries
+// while a capture is in progress.
+type HARRecorder struct {
+	mu      sync.Mutex
+	capture *capture
+}
+
+// capture is a HAR capture in progress.
+type capture struct {
+	HARCapture
+	started time.Time
+	dumper  *dumper
+
+	mu      sync.Mutex
+	entries []har.Entry
+	dropped int
+}
+
+// Errors returned when starting or stopping HAR capture.
+var (
+	ErrCaptureInProgress = errors.New("HAR capture already in progress")
+	ErrNoCapture         = errors.New("no HAR capture in progress")
+)
+
+func NewHARRecorder() *HARRecorder {
+	return &HARRecorder{}
+}
+
+// Start starts a capture, replacing defaults for zero options.
+func (h *HARRecorder) Start(opts HARCapture) error {
+	if _, err := path.Match(opts.Path, ""); err != nil {
+		return fmt.Errorf("%s: invalid path pattern: %w", opts.Path, err)
+	}
+	if opts.MaxBodyBytes == 0 {
+		opts.MaxBodyBytes = defaultHARMaxBody
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = defaultHARMaxEntries
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.capture != nil {
+		return ErrCaptureInProgress
+	}
+	h.capture = &capture{
+		HARCapture: opts,
+		started:    time.Now(),
+		dumper:     &dumper{maxBody: max(opts.MaxBodyBytes, 0), showSecrets: opts.ShowSecrets},
+	}
+	slog.Info("HAR capture started", "routes", opts.Routes, "path", opts.Path)
+	return nil
+}
+
+// Stop stops capture in progress and returns requests recorded.
+func (h *HARRecorder) Stop() (*har.HAR, error) {
+	h.mu.Lock()
+	c := h.capture
+	h.capture = nil
+	h.mu.Unlock()
+	if c == nil {
+		return nil, ErrNoCapture
+	}
+	doc := c.har()
+	slog.Info("HAR capture stopped", "entries", len(doc.Log.Entries))
+	return doc, nil
+}
+
+// HAR returns requests recorded so far by capture in progress.
+func (h *HARRecorder) HAR() (*har.HAR, error) {
+	c := h.current()
+	if c == nil {
+		return nil, ErrNoCapture
+	}
+	return c.har(), nil
+}
+
+// Status returns state of capture.
+func (h *HARRecorder) Status() HARStatus {
+	c := h.current()
+	if c == nil {
+		return HARStatus{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	opts := c.HARCapture
+	return HARStatus{
+		Capturing: true,
+		Started:   &c.started,
+		Entries:   len(c.entries),
+		Dropped:   c.dropped,
+		Capture:   &opts,
+	}
+}
+
+func (h *HARRecorder) current() *capture {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.capture
+}
+
+func (c *capture) matches(source string, r *http.Request) bool {
+	if len(c.Routes) > 0 && !slices.Contains(c.Routes, source) {
+		return false
+	}
+	if c.Path != "" {
+		if ok, _ := path.Match(c.Path, r.URL.Path); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *capture) add(entry har.Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.MaxEntries {
+		c.dropped++
+		return
+	}
+	c.entries = append(c.entries, entry)
+}
+
+func (c *capture) har() *har.HAR {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var comment string
+	if c.dropped > 0 {
+		comment = fmt.Sprintf("%d requests over limit of %d not recorded", c.dropped, c.MaxEntries)
+	}
+	return har.New(c.entries, comment)
+}
+
+type harTimingsKey struct{}
+
+// harTimings records when phases of proxying a request to upstream
+// happened. Trace hooks may be called from different goroutines.
+type harTimings struct {
+	mu                       sync.Mutex
+	dnsStart, dnsDone        time.Time
+	connectStart, connectEnd time.Time
+	tlsStart, tlsDone        time.Time
+	gotConn, wrote           time.Time
+	firstByte                time.Time
+	serverIP                 string
+}
+
+func (t *harTimings) set(field *time.Time) {
+	t.mu.Lock()
+	*field = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *harTimings) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { t.set(&t.dnsStart) },
+		DNSDone:      func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone) },
+		ConnectStart: func(_, _ string) { t.set(&t.connectStart) },
+		ConnectDone:  func(_, _ string, _ error) { t.set(&t.connectEnd) },
+		TLSHandshakeStart: func() {
+			t.set(&t.tlsStart)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.set(&t.tlsDone)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.set(&t.gotConn)
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				t.mu.Lock()
+				t.serverIP = addr.IP.String()
+				t.mu.Unlock()
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.set(&t.wrote) },
+		GotFirstResponseByte: func() { t.set(&t.firstByte) },
+	}
+}
+
+// timings divides time from start to end into HAR phases. Time not
+// accounted for by upstream connection phases is counted as blocked.
+func (t *harTimings) timings(start, end time.Time) har.Timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	connectEnd := t.connectEnd
+	if t.tlsDone.After(connectEnd) {
+		connectEnd = t.tlsDone
+	}
+	timings := har.Timings{
+		DNS:     span(t.dnsStart, t.dnsDone),
+		Connect: span(t.connectStart, connectEnd),
+		SSL:     span(t.tlsStart, t.tlsDone),
+		Send:    max(span(t.gotConn, t.wrote), 0),
+		Wait:    max(span(t.wrote, t.firstByte), 0),
+		Receive: max(span(t.firstByte, end), 0),
+	}
+	timings.Blocked = max(span(start, end)-max(timings.DNS, 0)-max(timings.Connect, 0)-
+		timings.Send-timings.Wait-timings.Receive, 0)
+	return timings
+}
+
+// span returns milliseconds from start to end, or -1 if either is unknown.
+func span(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return -1
+	}
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
+
+// serve records requests on route with given source while a capture
+// matching them is in progress.
+func (h *HARRecorder) serve(source string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := h.current()
+		if c == nil || !c.matches(source, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		in := r
+		timings := &harTimings{}
+		r = r.WithContext(context.WithValue(r.Context(), harTimingsKey{}, timings))
+		var reqBody []byte
+		var reqSize int64
+		finish := func() {}
+		if r.Body != nil && r.Body != http.NoBody {
+			body := &bodyCapture{ReadCloser: r.Body, max: c.dumper.maxBody}
+			body.done = func(captured []byte, n int64) {
+				reqBody, reqSize = captured, n
+			}
+			r.Body = body
+			finish = body.finish
+		}
+		cw := &captureWriter{ResponseWriter: w, status: http.StatusOK, max: c.dumper.maxBody}
+		next.ServeHTTP(cw, r)
+		finish()
+		end := time.Now()
+
+		header := cw.header
+		if header == nil {
+			header = w.Header()
+		}
+		entry := har.Entry{
+			StartedDateTime: start,
+			Request:         c.request(in, reqBody, reqSize),
+			Response:        c.response(in, cw.status, header, cw.body.Bytes(), cw.bytes),
+			Timings:         timings.timings(start, end),
+			Comment:         "route " + source,
+		}
+		entry.Time = max(entry.Timings.Blocked, 0) + max(entry.Timings.DNS, 0) +
+			max(entry.Timings.Connect, 0) + entry.Timings.Send + entry.Timings.Wait + entry.Timings.Receive
+		timings.mu.Lock()
+		entry.ServerIPAddress = timings.serverIP
+		timings.mu.Unlock()
+		c.add(entry)
+	})
+}
+
+// transport traces requests to upstream for timings of a HAR entry.
+func (h *HARRecorder) transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		timings, ok := r.Context().Value(harTimingsKey{}).(*harTimings)
+		if !ok {
+			return next.RoundTrip(r)
+		}
+		ctx := httptrace.WithClientTrace(r.Context(), timings.clientTrace())
+		return next.RoundTrip(r.WithContext(ctx))
+	})
+}
+
+func (c *capture) request(r *http.Request, body []byte, size int64) har.Request {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	header := c.dumper.redact(r.Header)
+	cookies := har.Cookies(r.Cookies())
+	if !c.ShowSecrets {
+		cookies = redactCookies(cookies)
+	}
+	req := har.Request{
+		Method:      r.Method,
+		URL:         scheme + "://" + r.Host + r.URL.RequestURI(),
+		HTTPVersion: r.Proto,
+		Cookies:     cookies,
+		Headers:     har.Headers(header),
+		QueryString: har.QueryString(r.URL),
+		HeadersSize: -1,
+		BodySize:    size,
+	}
+	if size > 0 {
+		text, encoding := contentText(body)
+		req.PostData = &har.PostData{MimeType: r.Header.Get("Content-Type"), Text: text}
+		// HAR has no encoding for request bodies.
+		var comments []string
+		if encoding != "" {
+			comments = append(comments, "encoded in base64")
+		}
+		if int64(len(body)) < size {
+			comments = append(comments, truncated(len(body), size))
+		}
+		req.PostData.Comment = strings.Join(comments, ", ")
+	}
+	return req
+}
+
+func (c *capture) response(r *http.Request, status int, header http.Header, body []byte, size int64) har.Response {
+	cookies := har.Cookies((&http.Response{Header: header}).Cookies())
+	if !c.ShowSecrets {
+		cookies = redactCookies(cookies)
+	}
+	resp := har.Response{
+		Status:      status,
+		StatusText:  http.StatusText(status),
+		HTTPVersion: r.Proto,
+		Cookies:     cookies,
+		Headers:     har.Headers(c.dumper.redact(header)),
+		RedirectURL: header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    size,
+		Content: har.Content{
+			Size:     size,
+			MimeType: header.Get("Content-Type"),
+		},
+	}
+	if len(body) == 0 {
+		return resp
+	}
+	captured := len(body)
+	complete := int64(captured) == size
+	decodedComplete := true
+	if header.Get("Content-Encoding") == "gzip" {
+		if decoded, n, err := gunzip(body, c.dumper.maxBody); err == nil {
+			body = decoded
+			decodedComplete = n <= c.dumper.maxBody
+			if complete && decodedComplete {
+				resp.Content.Size = n
+			}
+		}
+	}
+	resp.Content.Text, resp.Content.Encoding = contentText(body)
+	switch {
+	case !complete:
+		resp.Content.Comment = truncated(captured, size)
+	case !decodedComplete:
+		resp.Content.Comment = fmt.Sprintf("truncated to %d bytes after decompression", len(body))
+	}
+	return resp
+}
+
+// gunzip decompresses up to max bytes of data, returning as much as could be
+// decompressed if data is truncated. Returned size exceeds max if there is
+// more to decompress, which is left undone so that small bodies can't expand
+// without bounds.
+func gunzip(data []byte, max int64) (decoded []byte, n int64, err error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
+	}
+	decoded, err = io.ReadAll(io.LimitReader(zr, max+1))
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	n = int64(len(decoded))
+	return decoded[:min(n, max)], n, err
+}
+
+// contentText returns body as text, base64 encoded unless it is valid UTF-8.
+func contentText(body []byte) (text, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func truncated(n int, size int64) string {
+	return fmt.Sprintf("truncated to %d of %d bytes", n, size)
+}
+
+func redactCookies(cookies []har.Cookie) []har.Cookie {
+	for i := range cookies {
+		cookies[i].Value = "[REDACTED]"
+	}
+	return cookies
+}
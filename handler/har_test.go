@@ -0,0 +1,146 @@
+package handler_test
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akojo/legion/handler"
+	"github.com/akojo/legion/har"
+)
+
+func TestRecordHAR(t *testing.T) {
+	recorder := handler.NewHARRecorder()
+	url := harRoute(t, recorder)
+	err := recorder.Start(handler.HARCapture{Path: "/api/*", MaxBodyBytes: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := strings.Repeat("hello ", 20)
+	req, _ := http.NewRequest("POST", url+"/api/echo?q=a+b", strings.NewReader(body))
+	req.Header.Set("Cookie", "session=secret")
+	send(t, req)
+	req, _ = http.NewRequest("GET", url+"/other", nil)
+	send(t, req)
+
+	doc, err := recorder.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Log.Version != "1.2" || len(doc.Log.Entries) != 1 {
+		t.Fatalf("want 1 entry, got %+v", doc.Log)
+	}
+	entry := doc.Log.Entries[0]
+	if entry.Request.URL != url+"/api/echo?q=a+b" || entry.Request.Method != "POST" {
+		t.Errorf("request: got %s %s", entry.Request.Method, entry.Request.URL)
+	}
+	if q := entry.Request.QueryString; len(q) != 1 || q[0] != (har.NameValue{Name: "q", Value: "a b"}) {
+		t.Errorf("query string: got %v", q)
+	}
+	if c := entry.Request.Cookies; len(c) != 1 || c[0].Value != "[REDACTED]" {
+		t.Errorf("cookies: want redacted, got %v", c)
+	}
+	if p := entry.Request.PostData; p == nil || p.Text != body[:100] || entry.Request.BodySize != 120 {
+		t.Errorf("post data: want truncated body, got %+v", p)
+	}
+	resp := entry.Response
+	if resp.Status != http.StatusOK || resp.Content.Text != body[:100] || resp.Content.Comment != "truncated to 100 bytes after decompression" {
+		t.Errorf("response: want decoded body truncated, got %+v", resp)
+	}
+
+	timings := entry.Timings
+	if timings.Connect < 0 || timings.Send < 0 || timings.Wait < 0 || timings.Receive < 0 || timings.SSL != -1 {
+		t.Errorf("timings: got %+v", timings)
+	}
+	// No DNS lookup for IP address.
+	if timings.DNS != -1 {
+		t.Errorf("dns: want -1, got %f", timings.DNS)
+	}
+	sum := timings.Blocked + timings.Connect + timings.Send + timings.Wait + timings.Receive
+	if entry.Time <= 0 || entry.Time-sum > 1e-6 || sum-entry.Time > 1e-6 {
+		t.Errorf("time: want sum of timings %f, got %f", sum, entry.Time)
+	}
+	if entry.ServerIPAddress != "127.0.0.1" {
+		t.Errorf("server IP: got %s", entry.ServerIPAddress)
+	}
+}
+
+func TestHARCompressedBodyLimit(t *testing.T) {
+	recorder := handler.NewHARRecorder()
+	url := harRoute(t, recorder)
+	if err := recorder.Start(handler.HARCapture{MaxBodyBytes: 4096}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compresses to about 2 KiB.
+	body := strings.Repeat("a", 1<<20)
+	req, _ := http.NewRequest("POST", url+"/echo", strings.NewReader(body))
+	send(t, req)
+
+	doc, err := recorder.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := doc.Log.Entries[0].Response.Content
+	if content.Text != body[:4096] || content.Comment != "truncated to 4096 bytes after decompression" {
+		t.Errorf("want decoded body truncated to 4096 bytes, got %d bytes, comment %q", len(content.Text), content.Comment)
+	}
+	if size := doc.Log.Entries[0].Response.BodySize; size >= 4096 {
+		t.Errorf("want compressed body under 4096 bytes, got %d", size)
+	}
+}
+
+func TestHARCaptureState(t *testing.T) {
+	recorder := handler.NewHARRecorder()
+	if _, err := recorder.Stop(); !errors.Is(err, handler.ErrNoCapture) {
+		t.Errorf("want ErrNoCapture, got %v", err)
+	}
+	if err := recorder.Start(handler.HARCapture{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Start(handler.HARCapture{}); !errors.Is(err, handler.ErrCaptureInProgress) {
+		t.Errorf("want ErrCaptureInProgress, got %v", err)
+	}
+	if s := recorder.Status(); !s.Capturing || s.Capture.MaxEntries != 1000 {
+		t.Errorf("status: want defaults, got %+v", s)
+	}
+	if err := handler.NewHARRecorder().Start(handler.HARCapture{Path: "["}); err == nil {
+		t.Error("want error for invalid path pattern")
+	}
+}
+
+// harRoute returns URL of a server with a proxy route on /, recorded in
+// recorder, whose upstream echoes request body gzip encoded.
+func harRoute(t *testing.T, recorder *handler.HARRecorder) string {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		io.Copy(zw, r.Body)
+		zw.Close()
+	}))
+	t.Cleanup(upstream.Close)
+	h := handler.New()
+	if err := h.ReverseProxy("/", upstream.URL, handler.RecordHAR(recorder)); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func send(t *testing.T, req *http.Request) {
+	t.Helper()
+	// Compressed response is recorded as is, not decompressed by client.
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long requests wait in queue for their turn unless set otherwise.
+const defaultQueueTimeout = time.Second
+
+// Limiters keeps concurrency limiters of routes, identified by route source.
+// A route built on configuration reload with unchanged limits shares the
+// limiter of its predecessor, so that requests still in progress on the old
+// route count against the limit.
+type Limiters struct {
+	mu       sync.Mutex
+	limiters map[string]*limiter
+}
+
+func NewLimiters() *Limiters {
+	return &Limiters{limiters: map[string]*limiter{}}
+}
+
+// get returns limiter of route with given source, replacing it if limits
+// have changed.
+func (l *Limiters) get(source string, max, queue int, timeout time.Duration) *limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lim, ok := l.limiters[source]
+	if !ok || lim.max != max || lim.queue != queue || lim.timeout != timeout {
+		lim = newLimiter(max, queue, timeout)
+		l.limiters[source] = lim
+	}
+	return lim
+}
+
+// limiter serves at most max requests concurrently. Up to queue requests
+// over the limit wait for at most timeout for their turn, the rest are
+// responded to with 503 Service Unavailable.
+type limiter struct {
+	max     int
+	queue   int
+	timeout time.Duration
+
+	slots   chan struct{}
+	waiting chan struct{}
+}
+
+func newLimiter(max, queue int, timeout time.Duration) *limiter {
+	return &limiter{
+		max:     max,
+		queue:   queue,
+		timeout: timeout,
+		slots:   make(chan struct{}, max),
+		waiting: make(chan struct{}, queue),
+	}
+}
+
+func (l *limiter) serve(next http.Handler) http.Handler {
+	timeout := l.timeout
+	if timeout <= 0 {
+		timeout = defaultQueueTimeout
+	}
+	reject := func(w http.ResponseWriter, r *http.Request, reason string) {
+		slog.Warn("route concurrency limit exceeded", "path", r.URL.Path, "reason", reason)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			select {
+			case l.waiting <- struct{}{}:
+			default:
+				reject(w, r, "queue full")
+				return
+			}
+			timer := time.NewTimer(timeout)
+			select {
+			case l.slots <- struct{}{}:
+				<-l.waiting
+				timer.Stop()
+			case <-timer.C:
+				<-l.waiting
+				reject(w, r, "queue timeout")
+				return
+			case <-r.Context().Done():
+				<-l.waiting
+				timer.Stop()
+				return
+			}
+		}
+		defer func() { <-l.slots }()
+		next.ServeHTTP(w, r)
+	})
+}
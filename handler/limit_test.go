@@ -0,0 +1,110 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akojo/legion/handler"
+)
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	url, release := limitedRoute(t, handler.MaxConcurrentRequests(1, 0, 0))
+	defer close(release)
+
+	if status := getStatus(t, url); status != http.StatusServiceUnavailable {
+		t.Errorf("want 503, got %d", status)
+	}
+}
+
+func TestConcurrentRequestQueue(t *testing.T) {
+	url, release := limitedRoute(t, handler.MaxConcurrentRequests(1, 1, time.Second))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+
+	if status := getStatus(t, url); status != http.StatusOK {
+		t.Errorf("want 200, got %d", status)
+	}
+}
+
+func TestConcurrentRequestQueueTimeout(t *testing.T) {
+	url, release := limitedRoute(t, handler.MaxConcurrentRequests(1, 1, 50*time.Millisecond))
+	defer close(release)
+
+	if status := getStatus(t, url); status != http.StatusServiceUnavailable {
+		t.Errorf("want 503, got %d", status)
+	}
+}
+
+func TestSharedLimiters(t *testing.T) {
+	limiters := handler.NewLimiters()
+	upstream, release := blockingUpstream(t)
+	defer close(release)
+	block(t, serveRoute(t, upstream, handler.MaxConcurrentRequests(1, 0, 0), handler.ShareLimiters(limiters)))
+
+	// Routes rebuilt on reload.
+	url := serveRoute(t, upstream, handler.MaxConcurrentRequests(1, 0, 0), handler.ShareLimiters(limiters))
+	if status := getStatus(t, url); status != http.StatusServiceUnavailable {
+		t.Errorf("same limits: want 503, got %d", status)
+	}
+	url = serveRoute(t, upstream, handler.MaxConcurrentRequests(2, 0, 0), handler.ShareLimiters(limiters))
+	if status := getStatus(t, url); status != http.StatusOK {
+		t.Errorf("changed limits: want 200, got %d", status)
+	}
+}
+
+// limitedRoute proxies a route to an upstream whose requests to /block block
+// until release is closed, with one such request in progress.
+func limitedRoute(t *testing.T, opt handler.Option) (string, chan struct{}) {
+	upstream, release := blockingUpstream(t)
+	url := serveRoute(t, upstream, opt)
+	block(t, url)
+	return url, release
+}
+
+// blockingUpstream returns URL of an upstream whose requests to /block block
+// until release is closed.
+func blockingUpstream(t *testing.T) (string, chan struct{}) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-release
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream.URL, release
+}
+
+// serveRoute serves route "/" proxied to upstream and returns its URL.
+func serveRoute(t *testing.T, upstream string, opts ...handler.Option) string {
+	h := handler.New()
+	if err := h.ReverseProxy("/", upstream, opts...); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return server.URL + "/"
+}
+
+// block starts a request to url that is in progress on return.
+func block(t *testing.T, url string) {
+	resp, err := http.Get(url + "block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+}
+
+func getStatus(t *testing.T, url string) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/akojo/legion/logger"
+	"github.com/akojo/legion/metrics"
+	"github.com/akojo/legion/tracing"
+)
+
+// logRoute records source of route matching request in access log, metrics
+// and trace.
+func logRoute(source string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.AddAttrs(r.Context(), slog.String("route", source))
+		metrics.SetRoute(r.Context(), source)
+		tracing.SetRoute(r.Context(), r.Method, source)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,128 @@
+package handler
+
+import "time"
+
+// Option configures a single route.
+type Option func(*route)
+
+type route struct {
+	clientCert string
+	grpc       bool
+
+	proxyProtocol int
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	maxConcurrent int
+	queue         int
+	queueTimeout  time.Duration
+	limiters      *Limiters
+
+	upstreams *Upstreams
+
+	dump        bool
+	dumpBody    int64
+	dumpSecrets bool
+
+	har *HARRecorder
+}
+
+// RequireClientCert restricts route to clients presenting a verified TLS
+// certificate whose subject or subject alternative name matches pattern.
+// Pattern syntax is that of path.Match.
+func RequireClientCert(pattern string) Option {
+	return func(r *route) {
+		r.clientCert = pattern
+	}
+}
+
+// GRPC proxies route as gRPC: HTTP/2 is used end to end, responses are
+// flushed message by message and upstream failures are reported as gRPC
+// errors.
+func GRPC() Option {
+	return func(r *route) {
+		r.grpc = true
+	}
+}
+
+// SendProxyProtocol sends PROXY protocol header of given version, 1 or 2,
+// with client addresses to upstream of a proxy route. Connections to upstream
+// are not reused between requests.
+func SendProxyProtocol(version int) Option {
+	return func(r *route) {
+		r.proxyProtocol = version
+	}
+}
+
+// ReadTimeout overrides server read timeout for reading request body on
+// route.
+func ReadTimeout(d time.Duration) Option {
+	return func(r *route) {
+		r.readTimeout = d
+	}
+}
+
+// WriteTimeout overrides server write timeout for writing response on route,
+// e.g. to allow long downloads or streaming responses.
+func WriteTimeout(d time.Duration) Option {
+	return func(r *route) {
+		r.writeTimeout = d
+	}
+}
+
+// MaxConcurrentRequests limits number of requests served concurrently on
+// route to max. Up to queue requests over the limit wait for their turn for
+// at most timeout, one second if zero. Other requests are responded to with
+// 503 Service Unavailable.
+func MaxConcurrentRequests(max, queue int, timeout time.Duration) Option {
+	return func(r *route) {
+		r.maxConcurrent = max
+		r.queue = queue
+		r.queueTimeout = timeout
+	}
+}
+
+// ShareLimiters keeps concurrency limiter of route in limiters, so that it is
+// carried over to a route with the same source and limits built on
+// configuration reload.
+func ShareLimiters(limiters *Limiters) Option {
+	return func(r *route) {
+		r.limiters = limiters
+	}
+}
+
+// TrackUpstreams tracks upstream of a proxy route in upstreams, through
+// which it can be drained or disabled.
+func TrackUpstreams(upstreams *Upstreams) Option {
+	return func(r *route) {
+		r.upstreams = upstreams
+	}
+}
+
+// Dump logs requests and responses of route, and for proxy routes requests
+// sent to and responses received from upstream, with headers and up to
+// maxBody bytes of bodies. Authorization and cookie headers are redacted
+// unless showSecrets is set.
+func Dump(maxBody int64, showSecrets bool) Option {
+	return func(r *route) {
+		r.dump = true
+		r.dumpBody = maxBody
+		r.dumpSecrets = showSecrets
+	}
+}
+
+// RecordHAR records requests on route in HAR captures started on recorder.
+func RecordHAR(recorder *HARRecorder) Option {
+	return func(r *route) {
+		r.har = recorder
+	}
+}
+
+func newRoute(opts []Option) route {
+	var r route
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return r
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Reloadable is an http.Handler whose underlying handler can be replaced
+// while serving requests. Requests already in flight finish with the handler
+// they started with.
+type Reloadable struct {
+	current atomic.Pointer[http.Handler]
+}
+
+func NewReloadable(h http.Handler) *Reloadable {
+	r := &Reloadable{}
+	r.Store(h)
+	return r
+}
+
+// Store replaces the handler serving new requests.
+func (r *Reloadable) Store(h http.Handler) {
+	r.current.Store(&h)
+}
+
+func (r *Reloadable) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	(*r.current.Load()).ServeHTTP(w, req)
+}
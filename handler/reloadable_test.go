@@ -0,0 +1,58 @@
+package handler_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/akojo/legion/handler"
+)
+
+func TestReloadable(t *testing.T) {
+	h := handler.NewReloadable(respond("old"))
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "slow")
+	}))
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	done := make(chan string)
+	go func() {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Error(err)
+			done <- ""
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		done <- string(body)
+	}()
+	<-started
+
+	h.Store(respond("new"))
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(t, resp); body != "new" {
+		t.Errorf("after reload: want 'new', got %#v", body)
+	}
+
+	close(release)
+	if body := <-done; body != "slow" {
+		t.Errorf("in-flight request: want 'slow', got %#v", body)
+	}
+}
+
+func respond(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	})
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/akojo/legion/logger"
+)
+
+// RequestIDHeader carries request ID to upstreams and back to clients.
+const RequestIDHeader = "X-Request-ID"
+
+// Longest request ID accepted from trusted proxies.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// AssignRequestIDs assigns an ID to each request, returned to client in
+// X-Request-ID response header and forwarded to upstreams by proxy routes.
+// ID sent by a trusted proxy in X-Request-ID header is used if present,
+// otherwise a UUIDv7 is generated. Must be wrapped by TrustProxies for
+// proxies to be trusted.
+func AssignRequestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !clientOf(r).trusted || !validRequestID(id) {
+			id = newRequestID()
+		}
+		logger.AddAttrs(r.Context(), slog.String("request_id", id))
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestID returns ID assigned to request by AssignRequestIDs, or an empty
+// string if none was.
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether id is non-empty, of reasonable length and
+// consists of printable ASCII characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a UUIDv7, which sorts by time of creation.
+func newRequestID() string {
+	var uuid [16]byte
+	rand.Read(uuid[:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(uuid[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(uuid[2:], uint32(ms))
+	uuid[6] = 0x70 | uuid[6]&0x0f // version 7
+	uuid[8] = 0x80 | uuid[8]&0x3f // RFC 9562 variant
+
+	var buf [36]byte
+	hex.Encode(buf[0:], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+	return string(buf[:])
+}
@@ -0,0 +1,86 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/akojo/legion/handler"
+)
+
+var uuidV7 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		remote string
+		header string
+		keep   bool
+	}{
+		{"192.0.2.1:1234", "", false},
+		{"192.0.2.1:1234", "abc-123", true},
+		{"192.0.2.1:1234", "has space", false},
+		{"192.0.2.1:1234", strings.Repeat("x", 129), false},
+		{"198.51.100.1:1234", "abc-123", false},
+	}
+	for _, test := range tests {
+		var got string
+		h := trustProxies(handler.AssignRequestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = handler.RequestID(r)
+		})))
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RemoteAddr = test.remote
+		if test.header != "" {
+			req.Header.Set("X-Request-ID", test.header)
+		}
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+
+		if test.keep && got != test.header {
+			t.Errorf("%s %q: want ID kept, got %s", test.remote, test.header, got)
+		}
+		if !test.keep && !uuidV7.MatchString(got) {
+			t.Errorf("%s %q: want generated UUIDv7, got %s", test.remote, test.header, got)
+		}
+		if header := resp.Header().Get("X-Request-ID"); header != got {
+			t.Errorf("%s %q: response header: want %s, got %s", test.remote, test.header, got, header)
+		}
+	}
+}
+
+func TestRequestIDsUnique(t *testing.T) {
+	seen := map[string]bool{}
+	h := handler.AssignRequestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen[handler.RequestID(r)] = true
+	}))
+	for range 1000 {
+		GET(h, "/")
+	}
+	if len(seen) != 1000 {
+		t.Errorf("want 1000 unique IDs, got %d", len(seen))
+	}
+}
+
+func TestProxyRequestID(t *testing.T) {
+	var upstream string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Get("X-Request-ID")
+		w.Header().Set("X-Request-ID", "from-upstream")
+	}))
+	defer server.Close()
+
+	h := trustProxies(handler.AssignRequestIDs(makeReverseProxy(t, "/", server.URL)))
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	req.Header.Set("X-Request-ID", "untrusted")
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+
+	if !uuidV7.MatchString(upstream) {
+		t.Errorf("upstream: want generated ID, got %s", upstream)
+	}
+	if got := resp.Result().Header.Values("X-Request-ID"); len(got) != 1 || got[0] != upstream {
+		t.Errorf("response: want %s, got %v", upstream, got)
+	}
+}
@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// withDeadlines replaces connection read and write deadlines set by server
+// with ones measured from the start of request.
+func withDeadlines(read, write time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		now := time.Now()
+		if read > 0 {
+			if err := rc.SetReadDeadline(now.Add(read)); err != nil {
+				slog.Debug("cannot set read deadline", "path", r.URL.Path, "error", err)
+			}
+		}
+		if write > 0 {
+			if err := rc.SetWriteDeadline(now.Add(write)); err != nil {
+				slog.Debug("cannot set write deadline", "path", r.URL.Path, "error", err)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
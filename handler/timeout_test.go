@@ -0,0 +1,42 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akojo/legion/handler"
+)
+
+func TestWriteTimeoutOverride(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("slow"))
+	}))
+	defer upstream.Close()
+
+	h := handler.New()
+	if err := h.ReverseProxy("/slow", upstream.URL, handler.WriteTimeout(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ReverseProxy("/fast", upstream.URL); err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewUnstartedServer(h)
+	proxy.Config.WriteTimeout = 50 * time.Millisecond
+	proxy.Start()
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(t, resp); body != "slow" {
+		t.Errorf("want 'slow', got %#v", body)
+	}
+
+	if _, err := http.Get(proxy.URL + "/fast"); err == nil {
+		t.Error("expect server write timeout on route without override")
+	}
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/akojo/legion/logger"
+	"github.com/akojo/legion/metrics"
+	"github.com/akojo/legion/proxyproto"
+)
+
+// parseTarget parses proxy target URL. In addition to plain URLs, targets
+// on Unix domain sockets can be given as either
+//
+//	unix:/path/to/app.sock:/base/path
+//	http+unix://%2Fpath%2Fto%2Fapp.sock/base/path
+//
+// in which case socket path is returned separately and target URL host is
+// set to localhost.
+func parseTarget(target string) (*url.URL, string, error) {
+	var socket, path string
+	if rest, ok := strings.CutPrefix(target, "unix:"); ok {
+		socket, path, _ = strings.Cut(rest, ":")
+	} else if rest, ok := strings.CutPrefix(target, "http+unix://"); ok {
+		host, p, _ := strings.Cut(rest, "/")
+		unescaped, err := url.PathUnescape(host)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: invalid socket path: %w", target, err)
+		}
+		socket, path = unescaped, "/"+p
+	} else {
+		u, err := url.Parse(target)
+		return u, "", err
+	}
+
+	if socket == "" {
+		return nil, "", fmt.Errorf("%s: missing socket path", target)
+	}
+	u, err := url.Parse("http://localhost" + path)
+	if err != nil {
+		return nil, "", err
+	}
+	return u, socket, nil
+}
+
+// newTransport returns a transport for proxying requests to an upstream.
+// Routes using default settings share http.DefaultTransport, all others get
+// a dedicated one.
+func newTransport(socket string, h2c, http2Only bool, proxyProtocol int) http.RoundTripper {
+	if socket == "" && !h2c && !http2Only && proxyProtocol == 0 {
+		return http.DefaultTransport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if h2c {
+		// Cleartext HTTP/2 with prior knowledge.
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	} else if http2Only {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+	}
+	if socket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+	if proxyProtocol != 0 {
+		// Connections carry addresses of a single client and can't be
+		// shared between requests.
+		transport.DisableKeepAlives = true
+		transport.DialContext = withProxyHeader(transport.DialContext, proxyProtocol)
+	}
+	return transport
+}
+
+type proxyHeaderKey struct{}
+
+// withProxyHeader wraps dial to send PROXY protocol header with client
+// addresses of request being proxied.
+func withProxyHeader(dial func(ctx context.Context, network, addr string) (net.Conn, error), version int) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		header, _ := ctx.Value(proxyHeaderKey{}).(proxyproto.Header)
+		if err := proxyproto.WriteHeader(conn, version, header); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// clientAddresses stores addresses of client connection in request context
+// for sending in PROXY protocol header.
+func clientAddresses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var header proxyproto.Header
+		if src, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+			header.Source = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+		}
+		if dst, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			header.Destination = proxyproto.AddrPort(dst)
+		}
+		ctx := context.WithValue(r.Context(), proxyHeaderKey{}, header)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// upstreamTransport logs upstream target of requests and time taken to
+// receive response headers from upstream, and records them in metrics and
+// upstream health if tracked.
+type upstreamTransport struct {
+	http.RoundTripper
+	target   string
+	upstream *upstream
+}
+
+func (t *upstreamTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(r)
+	duration := time.Since(start)
+	logger.AddAttrs(r.Context(),
+		slog.String("upstream", t.target),
+		slog.Duration("upstream_duration", duration))
+	metrics.ObserveUpstream(r.Context(), t.target, duration, err)
+	if t.upstream != nil {
+		t.upstream.observe(r.Context(), err)
+	}
+	return resp, err
+}
@@ -0,0 +1,154 @@
+package handler_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/akojo/legion/handler"
+	"github.com/akojo/legion/logger"
+	"github.com/akojo/legion/proxyproto"
+)
+
+func TestUnixSocketProxy(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	server := newUnixServer(t, socket, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Path; got != "/base/pets/1" {
+			t.Errorf("path: want /base/pets/1, got %#v", got)
+		}
+		if got := r.Host; got != "host.example.com" {
+			t.Errorf("Host: want host.example.com, got %#v", got)
+		}
+		if got := r.Header.Get("X-Forwarded-Proto"); got != "http" {
+			t.Errorf("X-Forwarded-Proto: want 'http', got %#v", got)
+		}
+		if got := r.Header.Get("X-Forwarded-For"); got != "192.0.2.1" {
+			t.Errorf("X-Forwarded-For: want 192.0.2.1, got %#v", got)
+		}
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	targets := []string{
+		"unix:" + socket + ":/base",
+		"http+unix://" + url.PathEscape(socket) + "/base",
+	}
+	for _, target := range targets {
+		resp := GET(makeReverseProxy(t, "/api", target), "http://host.example.com/api/pets/1")
+		if status := resp.Result().StatusCode; status != 204 {
+			t.Errorf("%s: want 204, got %d", target, status)
+		}
+	}
+}
+
+func TestMissingSocketPath(t *testing.T) {
+	for _, target := range []string{"unix:", "unix::/base", "http+unix:///base"} {
+		if err := handler.New().ReverseProxy("/", target); err == nil {
+			t.Errorf("%s: expect error", target)
+		}
+	}
+}
+
+func newUnixServer(t *testing.T, socket string, h http.Handler) *httptest.Server {
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(h)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	return server
+}
+
+func TestSendProxyProtocol(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	headers := make(chan proxyproto.Header, 2)
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			header, err := proxyproto.ReadHeader(r)
+			if err != nil {
+				t.Error(err)
+			}
+			headers <- header
+			if _, err := http.ReadRequest(r); err == nil {
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+			}
+			conn.Close()
+		}
+	}()
+
+	h := handler.New()
+	if err := h.ReverseProxy("/", "http://"+upstream.Addr().String(), handler.SendProxyProtocol(2)); err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(h)
+	defer proxy.Close()
+
+	for range 2 {
+		resp, err := http.Get(proxy.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		header := <-headers
+		if header.Source.Addr() != netip.MustParseAddr("127.0.0.1") {
+			t.Errorf("source: want 127.0.0.1, got %v", header.Source)
+		}
+		if header.Destination.String() != proxy.Listener.Addr().String() {
+			t.Errorf("destination: want %s, got %v", proxy.Listener.Addr(), header.Destination)
+		}
+	}
+}
+
+func TestSendProxyProtocolVersion(t *testing.T) {
+	h := handler.New()
+	if err := h.ReverseProxy("/", "http://localhost", handler.SendProxyProtocol(3)); err == nil {
+		t.Error("expect error")
+	}
+	if err := h.ReverseProxy("/grpc", "http://localhost", handler.GRPC(), handler.SendProxyProtocol(1)); err == nil {
+		t.Error("expect error with gRPC")
+	}
+}
+
+func TestUpstreamLogAttrs(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	h := logger.Middleware(log, makeReverseProxy(t, "/api", upstream.URL))
+	GET(h, "/api/pets")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["route"] != "/api" {
+		t.Errorf("route: want /api, got %v", entry["route"])
+	}
+	if entry["upstream"] != upstream.URL {
+		t.Errorf("upstream: want %s, got %v", upstream.URL, entry["upstream"])
+	}
+	if _, ok := entry["upstream_duration"]; !ok {
+		t.Error("missing upstream_duration")
+	}
+}
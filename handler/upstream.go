@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UpstreamState controls whether requests are proxied to an upstream.
+type UpstreamState string
+
+const (
+	// UpstreamEnabled proxies requests to upstream.
+	UpstreamEnabled UpstreamState = "enabled"
+	// UpstreamDraining responds to new requests with 503 Service
+	// Unavailable, while requests in progress finish.
+	UpstreamDraining UpstreamState = "draining"
+	// UpstreamDisabled responds to new requests with 503 Service
+	// Unavailable and cancels requests in progress.
+	UpstreamDisabled UpstreamState = "disabled"
+)
+
+// Upstreams tracks state and health of proxy route upstreams. Upstreams are
+// identified by target URL, and state is kept across configuration reloads
+// for routes sharing an Upstreams.
+type Upstreams struct {
+	mu        sync.Mutex
+	upstreams map[string]*upstream
+}
+
+// UpstreamStatus describes state and health of an upstream, as observed from
+// requests proxied to it.
+type UpstreamStatus struct {
+	Target            string        `json:"target"`
+	State             UpstreamState `json:"state"`
+	Healthy           bool          `json:"healthy"`
+	InFlight          int64         `json:"in_flight"`
+	Requests          int64         `json:"requests"`
+	Errors            int64         `json:"errors"`
+	ConsecutiveErrors int64         `json:"consecutive_errors"`
+	LastError         string        `json:"last_error,omitempty"`
+	LastErrorTime     *time.Time    `json:"last_error_time,omitempty"`
+	LastSuccessTime   *time.Time    `json:"last_success_time,omitempty"`
+}
+
+type upstream struct {
+	target   string
+	inFlight atomic.Int64
+	requests atomic.Int64
+	errors   atomic.Int64
+
+	mu          sync.Mutex
+	state       UpstreamState
+	consecutive int64
+	lastError   string
+	lastErrorAt time.Time
+	lastSuccess time.Time
+	// Canceled when upstream is disabled.
+	enabled context.Context
+	disable context.CancelFunc
+}
+
+func NewUpstreams() *Upstreams {
+	return &Upstreams{upstreams: map[string]*upstream{}}
+}
+
+func (u *Upstreams) get(target string) *upstream {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, ok := u.upstreams[target]
+	if !ok {
+		up = &upstream{target: target, state: UpstreamEnabled}
+		up.enabled, up.disable = context.WithCancel(context.Background())
+		u.upstreams[target] = up
+	}
+	return up
+}
+
+// Status returns status of upstream with given target.
+func (u *Upstreams) Status(target string) UpstreamStatus {
+	return u.get(target).status()
+}
+
+// SetState enables, drains or disables upstream with given target.
+func (u *Upstreams) SetState(target string, state UpstreamState) error {
+	switch state {
+	case UpstreamEnabled, UpstreamDraining, UpstreamDisabled:
+	default:
+		return fmt.Errorf("%s: invalid upstream state", state)
+	}
+	up := u.get(target)
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	if state == up.state {
+		return nil
+	}
+	switch {
+	case state == UpstreamDisabled:
+		up.disable()
+	case up.state == UpstreamDisabled:
+		up.enabled, up.disable = context.WithCancel(context.Background())
+	}
+	slog.Info("upstream state changed", "upstream", target, "from", up.state, "to", state)
+	up.state = state
+	return nil
+}
+
+func (up *upstream) status() UpstreamStatus {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	s := UpstreamStatus{
+		Target:            up.target,
+		State:             up.state,
+		Healthy:           up.consecutive == 0,
+		InFlight:          up.inFlight.Load(),
+		Requests:          up.requests.Load(),
+		Errors:            up.errors.Load(),
+		ConsecutiveErrors: up.consecutive,
+		LastError:         up.lastError,
+	}
+	if !up.lastErrorAt.IsZero() {
+		s.LastErrorTime = &up.lastErrorAt
+	}
+	if !up.lastSuccess.IsZero() {
+		s.LastSuccessTime = &up.lastSuccess
+	}
+	return s
+}
+
+// observe records result of a request to upstream. Requests canceled by
+// client are not counted as errors.
+func (up *upstream) observe(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	if err != nil {
+		up.errors.Add(1)
+		up.consecutive++
+		up.lastError = err.Error()
+		up.lastErrorAt = time.Now()
+	} else {
+		up.consecutive = 0
+		up.lastSuccess = time.Now()
+	}
+}
+
+// serve passes request to next if upstream is enabled, canceling it if
+// upstream gets disabled before request completes.
+func (up *upstream) serve(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		up.mu.Lock()
+		state, enabled := up.state, up.enabled
+		up.mu.Unlock()
+		if state != UpstreamEnabled {
+			http.Error(w, "upstream "+string(state), http.StatusServiceUnavailable)
+			return
+		}
+
+		up.requests.Add(1)
+		up.inFlight.Add(1)
+		defer up.inFlight.Add(-1)
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		stop := context.AfterFunc(enabled, cancel)
+		defer stop()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,103 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akojo/legion/handler"
+)
+
+func TestDrainUpstream(t *testing.T) {
+	upstreams := handler.NewUpstreams()
+	url, target, release := trackedRoute(t, upstreams)
+
+	if err := upstreams.SetState(target, handler.UpstreamDraining); err != nil {
+		t.Fatal(err)
+	}
+	if status := getStatus(t, url+"/"); status != http.StatusServiceUnavailable {
+		t.Errorf("want 503, got %d", status)
+	}
+	if s := upstreams.Status(target); s.InFlight != 1 {
+		t.Errorf("want request in flight while draining, got %d", s.InFlight)
+	}
+	close(release)
+
+	if err := upstreams.SetState(target, handler.UpstreamEnabled); err != nil {
+		t.Fatal(err)
+	}
+	if status := getStatus(t, url+"/"); status != http.StatusOK {
+		t.Errorf("want 200, got %d", status)
+	}
+}
+
+func TestDisableUpstream(t *testing.T) {
+	upstreams := handler.NewUpstreams()
+	url, target, release := trackedRoute(t, upstreams)
+	defer close(release)
+
+	if err := upstreams.SetState(target, handler.UpstreamDisabled); err != nil {
+		t.Fatal(err)
+	}
+	if status := getStatus(t, url+"/"); status != http.StatusServiceUnavailable {
+		t.Errorf("want 503, got %d", status)
+	}
+	deadline := time.Now().Add(time.Second)
+	for upstreams.Status(target).InFlight != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("request in flight not canceled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	upstreams := handler.NewUpstreams()
+	h := handler.New()
+	if err := h.ReverseProxy("/", "http://127.0.0.1:1", handler.TrackUpstreams(upstreams)); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	s := upstreams.Status("http://127.0.0.1:1")
+	if s.Healthy || s.Requests != 2 || s.Errors != 2 || s.ConsecutiveErrors != 2 || s.LastError == "" {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestInvalidUpstreamState(t *testing.T) {
+	err := handler.NewUpstreams().SetState("http://localhost", "paused")
+	if err == nil {
+		t.Error("want error for invalid state")
+	}
+}
+
+// trackedRoute proxies a route tracked in upstreams to an upstream whose
+// requests to /block block until release is closed, or request is canceled,
+// with one such request in progress. Returns URLs of route and upstream.
+func trackedRoute(t *testing.T, upstreams *handler.Upstreams) (string, string, chan struct{}) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			started <- struct{}{}
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	h := handler.New()
+	if err := h.ReverseProxy("/", upstream.URL, handler.TrackUpstreams(upstreams)); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	go http.Get(server.URL + "/block")
+	<-started
+	return server.URL, upstream.URL, release
+}
@@ -0,0 +1,98 @@
+// Package health serves liveness and readiness probes for orchestrators and
+// load balancers.
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Checker tracks whether server is ready to serve requests. Server is not
+// ready until it starts serving, while configuration is reloaded, after it
+// starts shutting down, or while any added check fails.
+type Checker struct {
+	started   atomic.Bool
+	serving   atomic.Bool
+	reloading atomic.Bool
+
+	mu     sync.Mutex
+	checks []func() error
+}
+
+func New() *Checker {
+	return &Checker{}
+}
+
+// SetServing marks server as serving requests, or as shutting down.
+func (c *Checker) SetServing(serving bool) {
+	if serving {
+		c.started.Store(true)
+	}
+	c.serving.Store(serving)
+}
+
+// SetReloading marks configuration as being reloaded.
+func (c *Checker) SetReloading(reloading bool) {
+	c.reloading.Store(reloading)
+}
+
+// AddCheck adds a check which must return nil for server to be ready.
+func (c *Checker) AddCheck(check func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check)
+}
+
+// Ready returns nil if server is ready, or an error telling why not.
+func (c *Checker) Ready() error {
+	switch {
+	case !c.started.Load():
+		return errors.New("starting")
+	case !c.serving.Load():
+		return errors.New("shutting down")
+	case c.reloading.Load():
+		return errors.New("reloading configuration")
+	}
+	c.mu.Lock()
+	checks := c.checks
+	c.mu.Unlock()
+	for _, check := range checks {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler responds to requests for liveness path with 200 OK as long as
+// server is running, and to requests for readiness path with 200 OK if
+// server is ready and 503 Service Unavailable otherwise. Other requests are
+// passed to next. Empty paths are not served.
+func (c *Checker) Handler(next http.Handler, liveness, readiness string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "":
+		case liveness:
+			respond(w, http.StatusOK, "ok")
+			return
+		case readiness:
+			if err := c.Ready(); err != nil {
+				respond(w, http.StatusServiceUnavailable, fmt.Sprintf("not ready: %s", err))
+			} else {
+				respond(w, http.StatusOK, "ready")
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func respond(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, msg)
+}
@@ -0,0 +1,52 @@
+package health_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akojo/legion/health"
+)
+
+func TestReadiness(t *testing.T) {
+	c := health.New()
+	h := c.Handler(http.NotFoundHandler(), "/healthz", "/readyz")
+	var upstreamErr error
+	c.AddCheck(func() error { return upstreamErr })
+
+	steps := []struct {
+		name   string
+		change func()
+		status int
+		body   string
+	}{
+		{"starting", func() {}, 503, "not ready: starting"},
+		{"serving", func() { c.SetServing(true) }, 200, "ready"},
+		{"reloading", func() { c.SetReloading(true) }, 503, "not ready: reloading configuration"},
+		{"reloaded", func() { c.SetReloading(false) }, 200, "ready"},
+		{"check failing", func() { upstreamErr = errors.New("/api/: no healthy upstreams") }, 503, "not ready: /api/: no healthy upstreams"},
+		{"check passing", func() { upstreamErr = nil }, 200, "ready"},
+		{"shutting down", func() { c.SetServing(false) }, 503, "not ready: shutting down"},
+	}
+	for _, step := range steps {
+		step.change()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != step.status || strings.TrimSpace(rec.Body.String()) != step.body {
+			t.Errorf("%s: want %d %s, got %d %s", step.name, step.status, step.body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	h := health.New().Handler(http.NotFoundHandler(), "/healthz", "/readyz")
+	for path, want := range map[string]int{"/healthz": 200, "/other": 404} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: want %d, got %d", path, want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/akojo/legion/config"
+	"github.com/akojo/legion/logger"
+)
+
+// logFiles holds log files by path. Application and access logs written to
+// the same path share a file.
+var logFiles = map[string]*logger.File{}
+
+// openLog returns writer for log file at path, or standard output if path
+// is empty. If lossy is set, entries are dropped when writing to file falls
+// behind, otherwise writers wait.
+func openLog(path string, rotate config.Rotate, lossy bool) (io.Writer, error) {
+	if path == "" {
+		return os.Stdout, nil
+	}
+	f, ok := logFiles[path]
+	if !ok {
+		var err error
+		f, err = logger.OpenFile(path, logger.Rotation{
+			MaxSize:    int64(rotate.MaxSize),
+			Daily:      rotate.Daily,
+			MaxBackups: rotate.MaxBackups,
+			Compress:   rotate.Compress,
+		})
+		if err != nil {
+			return nil, err
+		}
+		logFiles[path] = f
+	}
+	if lossy {
+		return f, nil
+	}
+	return f.Blocking(), nil
+}
+
+// reopenLogs reopens log files on reopenSignals, e.g. after logrotate has
+// moved them.
+func reopenLogs() {
+	if len(reopenSignals) == 0 || len(logFiles) == 0 {
+		return
+	}
+	reopen := make(chan os.Signal, 1)
+	signal.Notify(reopen, reopenSignals...)
+	for range reopen {
+		for path, f := range logFiles {
+			if err := f.Reopen(); err != nil {
+				slog.Error("failed to reopen log file", "file", path, "error", err)
+			}
+		}
+		slog.Info("log files reopened")
+	}
+}
+
+// closeLogs writes buffered entries and closes log files.
+func closeLogs() {
+	for _, f := range logFiles {
+		f.Close()
+	}
+}
@@ -0,0 +1,355 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of log entries buffered for writing before new ones are dropped or
+// writers wait for room.
+const fileQueueSize = 8192
+
+// Suffix of rotated files, before optional .gz
+const backupTimeFormat = "20060102-150405.000"
+
+// Rotation configures when log files are rotated and how many rotated files
+// are kept. Zero values disable respective features.
+type Rotation struct {
+	// MaxSize rotates file before it grows larger than MaxSize bytes.
+	MaxSize int64
+	// Daily rotates file when date changes.
+	Daily bool
+	// MaxBackups is number of rotated files to keep.
+	MaxBackups int
+	// Compress compresses rotated files with gzip.
+	Compress bool
+}
+
+// File is a log file that is written asynchronously, so that slow disks
+// don't hold up callers. If writes fall too far behind, new entries are
+// dropped and the number of dropped entries is reported on stderr, unless
+// written through Blocking.
+//
+// Rotated files are named after the file with a timestamp appended, e.g.
+// access.log.20060102-150405.000.gz.
+type File struct {
+	path     string
+	rotation Rotation
+
+	entries  chan []byte
+	reopen   chan chan error
+	quit     chan struct{}
+	done     chan struct{}
+	closing  sync.Once
+	dropped  atomic.Int64
+	cleaning sync.Mutex
+	compress sync.WaitGroup
+
+	// Owned by writer goroutine
+	file   *os.File
+	buf    *bufio.Writer
+	size   int64
+	opened time.Time
+}
+
+// OpenFile opens a log file for appending, creating it if necessary.
+func OpenFile(path string, rotation Rotation) (*File, error) {
+	f := &File{
+		path:     path,
+		rotation: rotation,
+		entries:  make(chan []byte, fileQueueSize),
+		reopen:   make(chan chan error),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.run()
+	return f, nil
+}
+
+// Write queues p for writing. It never blocks, p is dropped if queue is
+// full.
+func (f *File) Write(p []byte) (int, error) {
+	return f.enqueue(p, false)
+}
+
+// Blocking returns a writer to f that waits for room in queue instead of
+// dropping entries, for logs that must not be lost.
+func (f *File) Blocking() io.Writer {
+	return blockingWriter{f}
+}
+
+type blockingWriter struct {
+	f *File
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	return w.f.enqueue(p, true)
+}
+
+func (f *File) enqueue(p []byte, wait bool) (int, error) {
+	entry := make([]byte, len(p))
+	copy(entry, p)
+	select {
+	case <-f.quit:
+		return 0, os.ErrClosed
+	default:
+	}
+	if wait {
+		select {
+		case f.entries <- entry:
+		case <-f.quit:
+			return 0, os.ErrClosed
+		}
+		return len(p), nil
+	}
+	select {
+	case f.entries <- entry:
+	default:
+		f.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Reopen closes and reopens file, e.g. after it has been moved by
+// logrotate.
+func (f *File) Reopen() error {
+	reply := make(chan error)
+	select {
+	case f.reopen <- reply:
+		return <-reply
+	case <-f.quit:
+		return os.ErrClosed
+	}
+}
+
+// Close writes queued entries and closes file.
+func (f *File) Close() error {
+	f.closing.Do(func() { close(f.quit) })
+	<-f.done
+	return nil
+}
+
+func (f *File) run() {
+	defer close(f.done)
+	for {
+		select {
+		case entry := <-f.entries:
+			f.write(entry)
+			if len(f.entries) == 0 {
+				f.flush()
+			}
+		case reply := <-f.reopen:
+			f.drain()
+			f.close()
+			reply <- f.open()
+		case <-f.quit:
+			f.drain()
+			f.close()
+			f.compress.Wait()
+			return
+		}
+	}
+}
+
+// drain writes entries queued so far.
+func (f *File) drain() {
+	for len(f.entries) > 0 {
+		f.write(<-f.entries)
+	}
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.buf = bufio.NewWriterSize(file, 64*1024)
+	f.size = info.Size()
+	f.opened = info.ModTime()
+	if f.size == 0 {
+		f.opened = time.Now()
+	}
+	return nil
+}
+
+func (f *File) write(entry []byte) {
+	if dropped := f.dropped.Swap(0); dropped > 0 {
+		fmt.Fprintf(os.Stderr, "%s: dropped %d log entries\n", f.path, dropped)
+	}
+	if f.file != nil && f.needsRotation(len(entry)) {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: rotate failed: %v\n", f.path, err)
+		}
+	}
+	// File is closed if opening it failed, try again.
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			f.dropped.Add(1)
+			return
+		}
+	}
+	n, err := f.buf.Write(entry)
+	f.size += int64(n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: write failed: %v\n", f.path, err)
+	}
+}
+
+func (f *File) flush() {
+	if f.file == nil {
+		return
+	}
+	if err := f.buf.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: write failed: %v\n", f.path, err)
+	}
+}
+
+func (f *File) needsRotation(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.rotation.MaxSize > 0 && f.size+int64(n) > f.rotation.MaxSize {
+		return true
+	}
+	if f.rotation.Daily {
+		y1, m1, d1 := f.opened.Date()
+		y2, m2, d2 := time.Now().Date()
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+	return false
+}
+
+func (f *File) close() {
+	if f.file != nil {
+		f.flush()
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+func (f *File) rotate() error {
+	f.close()
+	backup := f.backupName(time.Now())
+	if err := os.Rename(f.path, backup); err != nil {
+		return errors.Join(err, f.open())
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if !f.rotation.Compress {
+		f.removeOldBackups()
+		return nil
+	}
+	f.compress.Add(1)
+	go func() {
+		defer f.compress.Done()
+		if err := gzipFile(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: compress failed: %v\n", backup, err)
+		}
+		f.removeOldBackups()
+	}()
+	return nil
+}
+
+// backupName returns unused name for a file rotated at t.
+func (f *File) backupName(t time.Time) string {
+	for ; ; t = t.Add(time.Millisecond) {
+		name := f.path + "." + t.Format(backupTimeFormat)
+		if !exists(name) && !exists(name+".gz") {
+			return name
+		}
+	}
+}
+
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+// removeOldBackups removes oldest rotated files in excess of MaxBackups.
+func (f *File) removeOldBackups() {
+	if f.rotation.MaxBackups <= 0 {
+		return
+	}
+	f.cleaning.Lock()
+	defer f.cleaning.Unlock()
+
+	backups := f.backups()
+	if len(backups) <= f.rotation.MaxBackups {
+		return
+	}
+	for _, backup := range backups[:len(backups)-f.rotation.MaxBackups] {
+		for _, name := range []string{backup, backup + ".gz"} {
+			if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "%s: remove failed: %v\n", name, err)
+			}
+		}
+	}
+}
+
+// backups returns names of rotated files without .gz suffix, oldest first.
+func (f *File) backups() []string {
+	dir, base := filepath.Split(f.path)
+	entries, _ := os.ReadDir(filepath.Clean(dir + "."))
+	var backups []string
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), base+".")
+		if !ok {
+			continue
+		}
+		stamp = strings.TrimSuffix(stamp, ".gz")
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		name := f.path + "." + stamp
+		if !slices.Contains(backups, name) {
+			backups = append(backups, name)
+		}
+	}
+	slices.Sort(backups)
+	return backups
+}
+
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := errors.Join(zw.Close(), out.Close()); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	in.Close()
+	return os.Remove(name)
+}
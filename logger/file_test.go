@@ -0,0 +1,154 @@
+package logger_test
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akojo/legion/logger"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f := openFile(t, path, logger.Rotation{})
+	f.Write([]byte("first\n"))
+	f.Write([]byte("second\n"))
+	f.Close()
+
+	if got := readFile(t, path); got != "first\nsecond\n" {
+		t.Errorf("want two entries, got %q", got)
+	}
+	if _, err := f.Write([]byte("late\n")); err == nil {
+		t.Error("write after close: expect error")
+	}
+}
+
+func TestFileBlocking(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f := openFile(t, path, logger.Rotation{})
+	w := f.Blocking()
+	const n = 50000
+	for range n {
+		if _, err := w.Write([]byte("entry\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	if got := strings.Count(readFile(t, path), "entry\n"); got != n {
+		t.Errorf("want %d entries, got %d", n, got)
+	}
+}
+
+func TestFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	os.WriteFile(path, []byte("old\n"), 0644)
+	f := openFile(t, path, logger.Rotation{})
+	f.Write([]byte("new\n"))
+	f.Close()
+
+	if got := readFile(t, path); got != "old\nnew\n" {
+		t.Errorf("want entry appended, got %q", got)
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	f := openFile(t, path, logger.Rotation{MaxSize: 10, MaxBackups: 2})
+	for _, entry := range []string{"entry 1\n", "entry 2\n", "entry 3\n", "entry 4\n"} {
+		f.Write([]byte(entry))
+	}
+	f.Close()
+
+	if got := readFile(t, path); got != "entry 4\n" {
+		t.Errorf("current file: want latest entry, got %q", got)
+	}
+	backups := backupFiles(t, path)
+	if len(backups) != 2 {
+		t.Fatalf("want 2 backups, got %v", backups)
+	}
+	for i, want := range []string{"entry 2\n", "entry 3\n"} {
+		if got := readFile(t, backups[i]); got != want {
+			t.Errorf("%s: want %q, got %q", backups[i], want, got)
+		}
+	}
+}
+
+func TestFileCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f := openFile(t, path, logger.Rotation{MaxSize: 10, Compress: true})
+	f.Write([]byte("entry 1\n"))
+	f.Write([]byte("entry 2\n"))
+	f.Close()
+
+	backups := backupFiles(t, path)
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".gz") {
+		t.Fatalf("want one compressed backup, got %v", backups)
+	}
+	gz, err := os.Open(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != "entry 1\n" {
+		t.Errorf("backup: want first entry, got %q", got)
+	}
+}
+
+func TestFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f := openFile(t, path, logger.Rotation{})
+	// Entries written before reopening go to moved file.
+	f.Write([]byte("before\n"))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("after\n"))
+	f.Close()
+
+	if got := readFile(t, path+".1"); got != "before\n" {
+		t.Errorf("moved file: want %q, got %q", "before\n", got)
+	}
+	if got := readFile(t, path); got != "after\n" {
+		t.Errorf("reopened file: want %q, got %q", "after\n", got)
+	}
+}
+
+func openFile(t *testing.T, path string, rotation logger.Rotation) *logger.File {
+	t.Helper()
+	f, err := logger.OpenFile(path, rotation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func backupFiles(t *testing.T, path string) []string {
+	t.Helper()
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backups
+}
@@ -0,0 +1,203 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Templates of Common and Combined Log Format used by Apache and nginx.
+const (
+	commonTemplate   = `{client_ip} - - [{time_clf}] "{method} {uri} {proto}" {status} {bytes}`
+	combinedTemplate = commonTemplate + ` "{referer}" "{user_agent}"`
+)
+
+// NewHandler returns a log handler writing records to w in given format:
+// "logfmt", "json", "common", "combined" or a template of fields in braces,
+// e.g. "{method} {path} {status}". Besides attributes of records, templates
+// can refer to
+//
+//	time      record time in RFC 3339 format
+//	time_clf  record time in Common Log Format, e.g. 10/Oct/2000:13:55:36 -0700
+//	level     record level
+//	msg       record message
+//	uri       path and query string of request
+//
+// Fields missing from a record are written as "-".
+func NewHandler(w io.Writer, format string, opts *slog.HandlerOptions) (slog.Handler, error) {
+	switch format {
+	case "", "logfmt":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	case "common":
+		format = commonTemplate
+	case "combined":
+		format = combinedTemplate
+	}
+	tmpl, err := parseTemplate(format)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &slog.HandlerOptions{}
+	}
+	return &templateHandler{w: w, mu: new(sync.Mutex), level: opts.Level, template: tmpl}, nil
+}
+
+// segment of a template is either literal text or a field reference.
+type segment struct {
+	text  string
+	field bool
+}
+
+func parseTemplate(format string) ([]segment, error) {
+	var tmpl []segment
+	rest := format
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			tmpl = append(tmpl, segment{text: rest})
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("%s: unterminated field in log format", format)
+		}
+		if start > 0 {
+			tmpl = append(tmpl, segment{text: rest[:start]})
+		}
+		name := rest[start+1 : start+end]
+		if name == "" {
+			return nil, fmt.Errorf("%s: empty field in log format", format)
+		}
+		tmpl = append(tmpl, segment{text: name, field: true})
+		rest = rest[start+end+1:]
+	}
+	for _, seg := range tmpl {
+		if seg.field {
+			return tmpl, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: unknown log format", format)
+}
+
+// templateHandler writes records as lines rendered from a template.
+type templateHandler struct {
+	w        io.Writer
+	mu       *sync.Mutex
+	level    slog.Leveler
+	template []segment
+	attrs    []slog.Attr
+	group    string
+}
+
+func (h *templateHandler) Enabled(_ context.Context, level slog.Level) bool {
+	min := slog.LevelInfo
+	if h.level != nil {
+		min = h.level.Level()
+	}
+	return level >= min
+}
+
+func (h *templateHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make(map[string]string)
+	for _, attr := range h.attrs {
+		addField(fields, "", attr)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		addField(fields, h.group, attr)
+		return true
+	})
+	if !r.Time.IsZero() {
+		fields["time"] = r.Time.Format(time.RFC3339)
+		fields["time_clf"] = r.Time.Format("02/Jan/2006:15:04:05 -0700")
+	}
+	fields["level"] = r.Level.String()
+	fields["msg"] = r.Message
+	if path, ok := fields["path"]; ok {
+		fields["uri"] = path
+		if query := fields["query"]; query != "" {
+			fields["uri"] += "?" + query
+		}
+	}
+
+	var b strings.Builder
+	for _, seg := range h.template {
+		if !seg.field {
+			b.WriteString(seg.text)
+		} else if value, ok := fields[seg.text]; ok && value != "" {
+			b.WriteString(escape(value))
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func addField(fields map[string]string, group string, attr slog.Attr) {
+	key := attr.Key
+	if group != "" {
+		key = group + "." + key
+	}
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		for _, a := range value.Group() {
+			addField(fields, key, a)
+		}
+		return
+	}
+	fields[key] = value.String()
+}
+
+func (h *templateHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	h2.attrs = append(h2.attrs, h.attrs...)
+	for _, attr := range attrs {
+		if h.group != "" {
+			attr = slog.Group(h.group, attr)
+		}
+		h2.attrs = append(h2.attrs, attr)
+	}
+	return &h2
+}
+
+func (h *templateHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	if h.group != "" {
+		name = h.group + "." + name
+	}
+	h2.group = name
+	return &h2
+}
+
+// escape escapes quotes, backslashes and control characters so that values
+// can't break up log lines.
+func escape(s string) string {
+	if !strings.ContainsFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f || r == '"' || r == '\\' }) {
+		return s
+	}
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akojo/legion/logger"
+)
+
+func TestCommonFormat(t *testing.T) {
+	line := logRequest(t, "common", "/index.html?lang=fi")
+	want := `192.0.2.1 - - [TIME] "GET /index.html?lang=fi HTTP/1.1" 200 5`
+	if got := maskTime(line); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestCombinedFormat(t *testing.T) {
+	line := logRequest(t, "combined", "/")
+	want := `192.0.2.1 - - [TIME] "GET / HTTP/1.1" 200 5 "https://example.com/\"quoted\"" "test/1.0"`
+	if got := maskTime(line); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestTemplateFormat(t *testing.T) {
+	line := logRequest(t, "{method} {path} {status} {grpc_status}", "/")
+	if want := "GET / 200 -"; line != want {
+		t.Errorf("want %s, got %s", want, line)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	line := logRequest(t, "json", "/")
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["status"] != float64(200) || entry["path"] != "/" {
+		t.Errorf("unexpected entry %v", entry)
+	}
+}
+
+func TestInvalidFormat(t *testing.T) {
+	for _, format := range []string{"xml", "{method", "{} {path}"} {
+		if _, err := logger.NewHandler(&bytes.Buffer{}, format, nil); err == nil {
+			t.Errorf("%s: expect error", format)
+		}
+	}
+}
+
+func TestTemplateLevel(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := logger.NewHandler(&buf, "{level} {msg} {group.key}", &slog.HandlerOptions{Level: slog.LevelWarn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(h)
+	log.Info("suppressed")
+	log.WithGroup("group").Warn("shown", "key", "value")
+	if got := buf.String(); got != "WARN shown value\n" {
+		t.Errorf("want 'WARN shown value', got %q", got)
+	}
+}
+
+func logRequest(t *testing.T, format, path string) string {
+	var buf bytes.Buffer
+	h, err := logger.NewHandler(&buf, format, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := logger.Middleware(slog.New(h), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Referer", `https://example.com/"quoted"`)
+	req.Header.Set("User-Agent", "test/1.0")
+	mw.ServeHTTP(httptest.NewRecorder(), req)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// maskTime replaces Common Log Format timestamp with TIME.
+func maskTime(line string) string {
+	start := strings.IndexByte(line, '[')
+	end := strings.IndexByte(line, ']')
+	if start < 0 || end < start {
+		return line
+	}
+	if _, err := time.Parse("02/Jan/2006:15:04:05 -0700", line[start+1:end]); err != nil {
+		return line
+	}
+	return line[:start+1] + "TIME" + line[end:]
+}
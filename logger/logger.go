@@ -1,37 +1,149 @@
 package logger
 
 import (
+	"context"
+	"crypto/tls"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func Middleware(log *slog.Logger, next http.Handler) http.HandlerFunc {
+// Option configures access logging.
+type Option func(*options)
+
+type options struct {
+	fields []string
+	skip   []string
+}
+
+// Fields restricts logged attributes to ones with given names, in addition
+// to time, level and message.
+func Fields(names ...string) Option {
+	return func(o *options) {
+		o.fields = names
+	}
+}
+
+// Skip excludes requests for given paths from access log.
+func Skip(paths ...string) Option {
+	return func(o *options) {
+		o.skip = append(o.skip, paths...)
+	}
+}
+
+type entryKey struct{}
+
+// entry collects attributes attached to a request's log entry by handlers.
+type entry struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// AddAttrs attaches attributes to access log entry of request with context
+// ctx. Attributes are ignored if request is not logged.
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if e, ok := ctx.Value(entryKey{}).(*entry); ok {
+		e.mu.Lock()
+		e.attrs = append(e.attrs, attrs...)
+		e.mu.Unlock()
+	}
+}
+
+func Middleware(log *slog.Logger, next http.Handler, opts ...Option) http.HandlerFunc {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(o.skip, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
 		start := time.Now()
 		writer := &responseWriter{ResponseWriter: w, status: 200}
+		e := &entry{}
+		r = r.WithContext(context.WithValue(r.Context(), entryKey{}, e))
+		var body *countingReader
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &countingReader{ReadCloser: r.Body}
+			r.Body = body
+		}
 
 		next.ServeHTTP(writer, r)
 
-		log.LogAttrs(
-			r.Context(),
-			slog.LevelInfo,
-			strconv.Itoa(writer.status)+" "+r.Method+" "+r.URL.Path,
+		proto := r.Proto
+		// HTTP/3 has no minor version, though quic-go reports HTTP/3.0.
+		if r.ProtoMajor == 3 {
+			proto = "HTTP/3"
+		}
+		attrs := []slog.Attr{
 			slog.String("method", r.Method),
-			slog.String("proto", r.Proto),
+			// HTTP/2 stream IDs are not exposed by net/http, so streams
+			// can't be told apart beyond protocol.
+			slog.String("proto", proto),
 			slog.String("path", r.URL.Path),
 			slog.String("address", r.Host),
 			slog.Int("status", writer.status),
 			slog.Duration("duration", time.Since(start)),
-			slog.String("user_agent", r.Header.Get("User-Agent")))
+			slog.String("user_agent", r.Header.Get("User-Agent")),
+			slog.Int64("bytes", writer.bytes),
+		}
+		if body != nil {
+			attrs = append(attrs, slog.Int64("request_bytes", body.bytes.Load()))
+		}
+		if r.URL.RawQuery != "" {
+			attrs = append(attrs, slog.String("query", r.URL.RawQuery))
+		}
+		if referer := r.Referer(); referer != "" {
+			attrs = append(attrs, slog.String("referer", referer))
+		}
+		if status := grpcStatus(writer.Header()); status != "" {
+			attrs = append(attrs, slog.String("grpc_status", status))
+		}
+		if r.TLS != nil {
+			attrs = append(attrs,
+				slog.String("tls_version", tls.VersionName(r.TLS.Version)),
+				slog.String("tls_cipher", tls.CipherSuiteName(r.TLS.CipherSuite)))
+			if len(r.TLS.VerifiedChains) > 0 {
+				attrs = append(attrs, slog.String("client_cert", r.TLS.VerifiedChains[0][0].Subject.String()))
+			}
+		}
+		e.mu.Lock()
+		attrs = append(attrs, e.attrs...)
+		e.mu.Unlock()
+		// Client IP resolved from forwarding headers is attached by handler,
+		// address of direct peer is used otherwise.
+		if !slices.ContainsFunc(attrs, func(attr slog.Attr) bool { return attr.Key == "client_ip" }) {
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				attrs = append(attrs, slog.String("client_ip", host))
+			}
+		}
+
+		if o.fields != nil {
+			attrs = slices.DeleteFunc(attrs, func(attr slog.Attr) bool {
+				return !slices.Contains(o.fields, attr.Key)
+			})
+		}
+
+		log.LogAttrs(
+			r.Context(),
+			slog.LevelInfo,
+			strconv.Itoa(writer.status)+" "+r.Method+" "+r.URL.Path,
+			attrs...)
 	}
 }
 
 type responseWriter struct {
 	http.ResponseWriter
 	status int
+	bytes  int64
 }
 
 func (rw *responseWriter) Status() int {
@@ -42,3 +154,38 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.status = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// countingReader counts bytes read from request body. Body may be read by
+// another goroutine, e.g. when proxying requests.
+type countingReader struct {
+	io.ReadCloser
+	bytes atomic.Int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.bytes.Add(int64(n))
+	return n, err
+}
+
+// grpcStatus returns gRPC status code sent either as a header, declared
+// trailer or undeclared trailer.
+func grpcStatus(header http.Header) string {
+	if status := header.Get("Grpc-Status"); status != "" {
+		return status
+	}
+	if status := header[http.TrailerPrefix+"Grpc-Status"]; len(status) > 0 {
+		return status[0]
+	}
+	return ""
+}
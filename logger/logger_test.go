@@ -0,0 +1,94 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akojo/legion/logger"
+)
+
+func TestAddAttrs(t *testing.T) {
+	entry := logJSON(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.AddAttrs(r.Context(), slog.String("upstream", "http://localhost:8080"))
+	}))
+	if entry["upstream"] != "http://localhost:8080" {
+		t.Errorf("upstream: want http://localhost:8080, got %v", entry["upstream"])
+	}
+}
+
+func TestRequestBytes(t *testing.T) {
+	entry := logJSON(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("hi"))
+	}))
+	if entry["request_bytes"] != float64(11) {
+		t.Errorf("request_bytes: want 11, got %v", entry["request_bytes"])
+	}
+	if entry["bytes"] != float64(2) {
+		t.Errorf("bytes: want 2, got %v", entry["bytes"])
+	}
+	if entry["client_ip"] != "192.0.2.1" {
+		t.Errorf("client_ip: want 192.0.2.1, got %v", entry["client_ip"])
+	}
+	if entry["query"] != "q=1" {
+		t.Errorf("query: want q=1, got %v", entry["query"])
+	}
+}
+
+func TestHTTP3Proto(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/3.0", 3, 0
+	logger.Middleware(log, http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(buf.String(), `"proto":"HTTP/3"`) {
+		t.Errorf("want proto HTTP/3, got %s", buf.String())
+	}
+}
+
+func TestFields(t *testing.T) {
+	entry := logJSON(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.AddAttrs(r.Context(), slog.String("route", "/"))
+	}), logger.Fields("status", "route"))
+	for _, key := range []string{"time", "level", "msg", "status", "route"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("missing %s", key)
+		}
+	}
+	if len(entry) != 5 {
+		t.Errorf("want 5 fields, got %v", entry)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	h := logger.Middleware(log, http.NotFoundHandler(), logger.Skip("/healthz"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	if buf.Len() != 0 {
+		t.Errorf("want no entry, got %s", buf.String())
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz/", nil))
+	if buf.Len() == 0 {
+		t.Error("want entry for other paths")
+	}
+}
+
+func logJSON(t *testing.T, h http.Handler, opts ...logger.Option) map[string]any {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	req := httptest.NewRequest("POST", "/?q=1", strings.NewReader("hello world"))
+	logger.Middleware(log, h, opts...).ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
@@ -1,20 +1,32 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/akojo/legion/admin"
 	"github.com/akojo/legion/config"
 	"github.com/akojo/legion/handler"
+	"github.com/akojo/legion/health"
 	"github.com/akojo/legion/logger"
+	"github.com/akojo/legion/metrics"
 	"github.com/akojo/legion/server"
+	"github.com/akojo/legion/tracing"
 )
 
 func main() {
 	var logLevel = new(slog.LevelVar)
-	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: logLevel,
-	})))
+	logOptions := &slog.HandlerOptions{Level: logLevel}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, logOptions)))
 
 	conf, err := config.ReadConfig(os.Args[1:])
 	if err != nil {
@@ -23,21 +35,76 @@ func main() {
 
 	logLevel.Set(conf.LogLevel.Level)
 
-	h := handler.New()
-	for _, route := range conf.Routes.Static {
-		err := h.FileServer(route.Source, route.Target)
-		if err != nil {
-			Fatal("invalid route", err)
-		}
+	// Application log entries, errors among them, are never dropped.
+	appOut, err := openLog(conf.Log.File, conf.Log.Rotate, false)
+	if err != nil {
+		Fatal("invalid log config", err)
 	}
-	for _, route := range conf.Routes.Proxy {
-		err := h.ReverseProxy(route.Source, route.Target)
+	appLog, err := logger.NewHandler(appOut, string(conf.Log.Format), logOptions)
+	if err != nil {
+		Fatal("invalid log config", err)
+	}
+	slog.SetDefault(slog.New(appLog))
+	accessOut, err := openLog(conf.AccessLog.File, conf.AccessLog.Rotate, true)
+	if err != nil {
+		Fatal("invalid access log config", err)
+	}
+	accessHandler, err := logger.NewHandler(accessOut, conf.AccessLog.Format, logOptions)
+	if err != nil {
+		Fatal("invalid access log config", err)
+	}
+	accessLog := slog.New(accessHandler)
+	go reopenLogs()
+
+	if conf.Tracing.Enabled {
+		err = tracing.Start(context.Background(), tracing.Options{
+			Protocol:    string(conf.Tracing.Protocol),
+			Endpoint:    conf.Tracing.Endpoint,
+			ServiceName: conf.Tracing.ServiceName,
+			SampleRatio: *conf.Tracing.SampleRatio,
+		})
 		if err != nil {
-			Fatal("invalid route", err)
+			Fatal("invalid tracing config", err)
 		}
 	}
 
-	srv := server.New(logger.Middleware(slog.Default(), h))
+	upstreams := handler.NewUpstreams()
+	limiters := handler.NewLimiters()
+	recorder := handler.NewHARRecorder()
+	checker := health.New()
+	h, err := newHandler(conf, upstreams, limiters, recorder, checker, accessLog)
+	if err != nil {
+		Fatal("invalid route", err)
+	}
+	routes := handler.NewReloadable(h)
+	reloader := newReloader(conf, routes, upstreams, limiters, recorder, checker, accessLog, logLevel)
+	checker.AddCheck(reloader.checkUpstreams)
+	go reloader.run()
+
+	var root http.Handler = routes
+	var m *metrics.Metrics
+	if conf.Admin.Metrics {
+		m = metrics.New()
+		root = m.Middleware(root)
+	}
+	var api *admin.API
+	if conf.Admin.API {
+		api = admin.New(reloader, upstreams, recorder, logLevel)
+		root = api.Middleware(root)
+	}
+	srv := server.New(root)
+	if conf.Admin.Addr != "" {
+		if !conf.Admin.AllowRemote && !localAddr(conf.Admin.Addr) {
+			Fatal("invalid admin config", fmt.Errorf("%s: admin listener must be on loopback address or Unix domain socket unless allow_remote is set", conf.Admin.Addr))
+		}
+		srv.SetAdmin(server.Listener{Addr: conf.Admin.Addr}, adminHandler(m, api, checker, conf.Health))
+	} else if conf.Admin.Metrics || conf.Admin.API {
+		Fatal("invalid admin config", errors.New("metrics and admin API require an admin listener"))
+	}
+	if m != nil {
+		srv.ObserveTLSHandshakes(m.ObserveTLSHandshake)
+	}
+	srv.ObserveReadiness(checker.SetServing)
 
 	for _, c := range conf.TLS.Certificates {
 		err = srv.AddTLSCertificate(c.CertFile, c.KeyFile)
@@ -45,14 +112,267 @@ func main() {
 			Fatal("invalid TLS config", err)
 		}
 	}
+	policy, err := tlsPolicy(conf.TLS)
+	if err != nil {
+		Fatal("invalid TLS config", err)
+	}
+	srv.SetTLSPolicy(policy)
+	if conf.TLS.OCSPStapling {
+		srv.EnableOCSPStapling()
+	}
+	if len(conf.TLS.Certificates) > 0 {
+		slog.Info("TLS policy", "policy", policy)
+	}
 
-	err = srv.ListenAndServe(conf.Addr)
+	if auth := conf.TLS.ClientAuth; auth != nil {
+		err = srv.SetClientAuth(auth.Mode.ClientAuthType, auth.CAFile, auth.Hosts)
+		if err != nil {
+			Fatal("invalid TLS client auth config", err)
+		}
+	}
+
+	srv.SetTimeouts(server.Timeouts{
+		ReadHeader: conf.Timeouts.ReadHeader,
+		Read:       conf.Timeouts.Read,
+		Write:      conf.Timeouts.Write,
+		Idle:       conf.Timeouts.Idle,
+		Shutdown:   conf.Timeouts.Shutdown,
+	})
+	srv.SetMaxHeaderBytes(conf.MaxHeaderBytes)
+	srv.SetConnLimits(server.ConnLimits{
+		MaxConns:      conf.Limits.MaxConns,
+		MaxConnsPerIP: conf.Limits.MaxConnsPerIP,
+	})
+
+	if conf.H2C {
+		srv.EnableH2C()
+	}
+	var listeners []server.Listener
+	for _, l := range conf.AllListeners() {
+		listeners = append(listeners, server.Listener{
+			Name:  l.Name,
+			Addr:  l.Addr,
+			Mode:  l.Mode.FileMode,
+			Owner: l.Owner,
+			Group: l.Group,
+
+			ProxyProtocol: proxyProtocol(l.ProxyProtocol),
+			MaxConns:      l.MaxConns,
+		})
+	}
+
+	if conf.HTTP3.Enabled {
+		addr := conf.HTTP3.Addr
+		if addr == "" {
+			addr = tcpAddr(listeners)
+		}
+		if addr == "" {
+			Fatal("invalid HTTP/3 config", errors.New("no TCP listener to share address with"))
+		}
+		srv.EnableHTTP3(addr)
+	}
+
+	err = srv.ListenAndServe(listeners...)
+	if errors.Is(err, server.ErrShutdownForced) {
+		Fatal("active requests interrupted", err)
+	}
 	if err != nil {
 		Fatal("server closed unexpectedly", err)
 	}
+	flush()
+}
+
+// adminHandler returns handler for endpoints enabled on admin listener.
+// Health endpoints are served on admin listener as well, which keeps
+// serving them while other listeners are shut down.
+func adminHandler(m *metrics.Metrics, api *admin.API, checker *health.Checker, conf config.Health) http.Handler {
+	mux := http.NewServeMux()
+	if m != nil {
+		mux.Handle("/metrics", m.Handler())
+	}
+	if api != nil {
+		mux.Handle("/api/", api)
+	}
+	if conf.Enabled {
+		return checker.Handler(mux, conf.Liveness, conf.Readiness)
+	}
+	return mux
+}
+
+// localAddr reports whether addr is a Unix domain socket or a TCP address
+// on loopback interface.
+func localAddr(addr string) bool {
+	if strings.HasPrefix(addr, "unix:") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+// newHandler builds routes defined in configuration, wrapped with tracing
+// and access logging to accessLog. Upstreams of proxy routes are tracked in
+// upstreams and recorded in HAR captures started on recorder, concurrency
+// limiters of routes are kept in limiters, and health endpoints report
+// readiness of checker.
+func newHandler(conf *config.Config, upstreams *handler.Upstreams, limiters *handler.Limiters, recorder *handler.HARRecorder, checker *health.Checker, accessLog *slog.Logger) (http.Handler, error) {
+	h := handler.New()
+	for _, route := range conf.Routes.Static {
+		opts := append(routeOptions(route.RouteOptions, conf.Dump), handler.ShareLimiters(limiters))
+		err := h.FileServer(route.Source, route.Target, opts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, route := range conf.Routes.Proxy {
+		opts := routeOptions(route.RouteOptions, conf.Dump)
+		switch route.Mode {
+		case "", "http":
+		case "grpc":
+			opts = append(opts, handler.GRPC())
+		default:
+			return nil, fmt.Errorf("%s: unknown proxy mode %q", route.Source, route.Mode)
+		}
+		opts = append(opts, handler.ShareLimiters(limiters), handler.TrackUpstreams(upstreams), handler.RecordHAR(recorder))
+		if route.SendProxyProtocol != 0 {
+			opts = append(opts, handler.SendProxyProtocol(route.SendProxyProtocol))
+		}
+		err := h.ReverseProxy(route.Source, route.Target, opts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	trusted := make([]netip.Prefix, len(conf.TrustedProxies))
+	for i, cidr := range conf.TrustedProxies {
+		trusted[i] = cidr.Prefix
+	}
+	var logOpts []logger.Option
+	if len(conf.AccessLog.Fields) > 0 {
+		logOpts = append(logOpts, logger.Fields(conf.AccessLog.Fields...))
+	}
+	next := tracing.Middleware(handler.TrustProxies(trusted, handler.AssignRequestIDs(h)))
+	if hc := conf.Health; hc.Enabled {
+		if err := checkMinHealthy(hc.MinHealthyUpstreams); err != nil {
+			return nil, err
+		}
+		for _, route := range conf.Routes.Proxy {
+			if route.MinHealthyUpstreams == nil {
+				continue
+			}
+			if err := checkMinHealthy(*route.MinHealthyUpstreams); err != nil {
+				return nil, fmt.Errorf("%s: %w", route.Source, err)
+			}
+		}
+		next = checker.Handler(next, hc.Liveness, hc.Readiness)
+		if !hc.AccessLog {
+			logOpts = append(logOpts, logger.Skip(hc.Liveness, hc.Readiness))
+		}
+	}
+	return logger.Middleware(accessLog, next, logOpts...), nil
+}
+
+func checkMinHealthy(n int) error {
+	if n < 0 || n > 1 {
+		return fmt.Errorf("min_healthy_upstreams: %d: must be 0 or 1, proxy routes have a single upstream", n)
+	}
+	return nil
+}
+
+// tcpAddr returns address of the first TCP listener.
+func tcpAddr(listeners []server.Listener) string {
+	for _, l := range listeners {
+		if _, ok := l.SocketPath(); !ok {
+			return l.Addr
+		}
+	}
+	return ""
+}
+
+func proxyProtocol(conf *config.ProxyProtocol) *server.ProxyProtocol {
+	if conf == nil {
+		return nil
+	}
+	pp := &server.ProxyProtocol{}
+	for _, cidr := range conf.Trusted {
+		pp.Trusted = append(pp.Trusted, cidr.Prefix)
+	}
+	return pp
+}
+
+// routeOptions returns options for a route, dumping requests as configured
+// by dump unless route overrides it.
+func routeOptions(conf config.RouteOptions, dump config.Dump) []handler.Option {
+	var opts []handler.Option
+	if conf.Dump != nil {
+		dump = *conf.Dump
+	}
+	if dump.Enabled {
+		opts = append(opts, handler.Dump(int64(dump.BodyBytes), dump.ShowSecrets))
+	}
+	if conf.ClientCert != "" {
+		opts = append(opts, handler.RequireClientCert(conf.ClientCert))
+	}
+	if conf.MaxConcurrentRequests > 0 {
+		opts = append(opts, handler.MaxConcurrentRequests(conf.MaxConcurrentRequests, conf.Queue, conf.QueueTimeout))
+	}
+	if conf.ReadTimeout > 0 {
+		opts = append(opts, handler.ReadTimeout(conf.ReadTimeout))
+	}
+	if conf.WriteTimeout > 0 {
+		opts = append(opts, handler.WriteTimeout(conf.WriteTimeout))
+	}
+	return opts
+}
+
+func tlsPolicy(conf config.TLS) (server.TLSPolicy, error) {
+	policy := server.DefaultTLSPolicy()
+	if conf.Preset != "" {
+		var err error
+		policy, err = server.TLSPreset(conf.Preset)
+		if err != nil {
+			return policy, err
+		}
+	}
+	if conf.MinVersion.Version != 0 {
+		policy.MinVersion = conf.MinVersion.Version
+	}
+	if len(conf.CipherSuites) > 0 {
+		policy.CipherSuites = make([]uint16, len(conf.CipherSuites))
+		for i, suite := range conf.CipherSuites {
+			policy.CipherSuites[i] = suite.ID
+		}
+	}
+	if len(conf.Curves) > 0 {
+		policy.CurvePreferences = make([]tls.CurveID, len(conf.Curves))
+		for i, curve := range conf.Curves {
+			policy.CurvePreferences[i] = curve.ID
+		}
+	}
+	if len(conf.ALPN) > 0 {
+		policy.NextProtos = conf.ALPN
+	}
+	policy.TicketKeyRotation = conf.SessionTicketRotation
+	return policy, nil
 }
 
 func Fatal(msg string, err error) {
 	slog.Error(msg, "error", err)
+	flush()
 	os.Exit(1)
 }
+
+// flush exports pending spans and writes buffered log entries before exit.
+func flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracing.Shutdown(ctx); err != nil {
+		slog.Warn("failed to export spans", "error", err)
+	}
+	closeLogs()
+}
@@ -0,0 +1,181 @@
+// Package metrics collects request, upstream and TLS metrics and exposes
+// them in Prometheus format.
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds metrics of a server. Requests are labelled with source of
+// the route they matched rather than their path, so that number of time
+// series stays bounded.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	duration         *prometheus.HistogramVec
+	inFlight         prometheus.Gauge
+	responseSize     *prometheus.HistogramVec
+	upstreamDuration *prometheus.HistogramVec
+	upstreamErrors   *prometheus.CounterVec
+	tlsHandshakes    *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "legion_http_requests_total",
+			Help: "Number of HTTP requests served.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "legion_http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "legion_http_requests_in_flight",
+			Help: "Number of HTTP requests being served.",
+		}),
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "legion_http_response_size_bytes",
+			Help:    "Size of HTTP response bodies.",
+			Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+		}, []string{"route"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "legion_upstream_duration_seconds",
+			Help:    "Time taken to receive response headers from upstream.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"upstream"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "legion_upstream_errors_total",
+			Help: "Number of requests to upstream that failed without a response.",
+		}, []string{"upstream"}),
+		tlsHandshakes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "legion_tls_handshakes_total",
+			Help: "Number of TLS handshakes, by TLS version of successful ones.",
+		}, []string{"version", "result"}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.duration, m.inFlight, m.responseSize,
+		m.upstreamDuration, m.upstreamErrors, m.tlsHandshakes,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler returns a handler serving metrics in Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveTLSHandshake counts a TLS handshake, which failed if err is not
+// nil.
+func (m *Metrics) ObserveTLSHandshake(version uint16, err error) {
+	if err != nil {
+		m.tlsHandshakes.WithLabelValues("", "error").Inc()
+		return
+	}
+	m.tlsHandshakes.WithLabelValues(tls.VersionName(version), "ok").Inc()
+}
+
+type requestKey struct{}
+
+// request holds metrics of a request in progress.
+type request struct {
+	metrics *Metrics
+	route   string
+}
+
+// Middleware records metrics of requests served by next.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		req := &request{metrics: m}
+		writer := &responseWriter{ResponseWriter: w, status: 200}
+		next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), requestKey{}, req)))
+
+		method, code := methodLabel(r.Method), statusClass(writer.status)
+		m.requests.WithLabelValues(req.route, method, code).Inc()
+		m.duration.WithLabelValues(req.route, method, code).Observe(time.Since(start).Seconds())
+		m.responseSize.WithLabelValues(req.route).Observe(float64(writer.bytes))
+	})
+}
+
+// SetRoute sets route label of request with context ctx to source of
+// matching route. Requests matching no route have an empty route label.
+func SetRoute(ctx context.Context, source string) {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.route = source
+	}
+}
+
+// ObserveUpstream records time taken by a request to upstream, which
+// failed if err is not nil. Upstream is identified by configured target
+// rather than resolved address.
+func ObserveUpstream(ctx context.Context, upstream string, duration time.Duration, err error) {
+	req, ok := ctx.Value(requestKey{}).(*request)
+	if !ok {
+		return
+	}
+	if err != nil {
+		// Requests canceled by client didn't fail because of upstream.
+		if ctx.Err() == nil {
+			req.metrics.upstreamErrors.WithLabelValues(upstream).Inc()
+		}
+		return
+	}
+	req.metrics.upstreamDuration.WithLabelValues(upstream).Observe(duration.Seconds())
+}
+
+// methodLabel returns method, or OTHER for non-standard methods.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodConnect,
+		http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// statusClass returns status code class, e.g. 2xx.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	rw.status = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,94 @@
+package metrics_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akojo/legion/metrics"
+)
+
+func TestRequests(t *testing.T) {
+	m := metrics.New()
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			metrics.SetRoute(r.Context(), "/api")
+			w.Write([]byte("hello"))
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/api/users/1", nil),
+		httptest.NewRequest("GET", "/api/users/2", nil),
+		httptest.NewRequest("PURGE", "/api/users/3", nil),
+		httptest.NewRequest("GET", "/missing", nil),
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`legion_http_requests_total{code="2xx",method="GET",route="/api"} 2`,
+		`legion_http_requests_total{code="2xx",method="OTHER",route="/api"} 1`,
+		`legion_http_requests_total{code="4xx",method="GET",route=""} 1`,
+		`legion_http_request_duration_seconds_count{code="2xx",method="GET",route="/api"} 2`,
+		`legion_http_response_size_bytes_sum{route="/api"} 15`,
+		`legion_http_requests_in_flight 0`,
+		`go_goroutines `,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+func TestUpstream(t *testing.T) {
+	m := metrics.New()
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.ObserveUpstream(r.Context(), "http://backend", 10*time.Millisecond, nil)
+		metrics.ObserveUpstream(r.Context(), "http://backend", 0, errors.New("connection refused"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`legion_upstream_duration_seconds_count{upstream="http://backend"} 1`,
+		`legion_upstream_errors_total{upstream="http://backend"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+func TestTLSHandshakes(t *testing.T) {
+	m := metrics.New()
+	m.ObserveTLSHandshake(0x0304, nil)
+	m.ObserveTLSHandshake(0, errors.New("bad certificate"))
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`legion_tls_handshakes_total{result="ok",version="TLS 1.3"} 1`,
+		`legion_tls_handshakes_total{result="error",version=""} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	if w.Code != 200 {
+		t.Fatalf("want 200, got %d", w.Code)
+	}
+	return string(body)
+}
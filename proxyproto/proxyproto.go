@@ -0,0 +1,199 @@
+// Package proxyproto reads and writes PROXY protocol headers used by load
+// balancers to pass original client addresses over TCP connections.
+//
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Signature starting version 2 headers.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Maximum length of a version 1 header, including CRLF.
+const v1MaxLength = 107
+
+var ErrNoHeader = errors.New("PROXY protocol header missing")
+
+// Header holds addresses of the original connection. Source and Destination
+// are invalid if the header does not carry addresses, e.g. for health checks
+// by load balancer.
+type Header struct {
+	Source      netip.AddrPort
+	Destination netip.AddrPort
+}
+
+// ReadHeader reads a version 1 or 2 header from r.
+func ReadHeader(r *bufio.Reader) (Header, error) {
+	// Shortest valid header is longer than v2 signature.
+	start, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return Header{}, err
+	}
+	switch {
+	case bytes.Equal(start, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readV1(r)
+	}
+	return Header{}, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (Header, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= v1MaxLength {
+			return Header{}, errors.New("PROXY protocol v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return Header{}, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return Header{}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return Header{}, fmt.Errorf("invalid PROXY protocol v1 header %q", line)
+	}
+	src, err := parseAddrPort(fields[2], fields[4])
+	if err != nil {
+		return Header{}, err
+	}
+	dst, err := parseAddrPort(fields[3], fields[5])
+	if err != nil {
+		return Header{}, err
+	}
+	return Header{Source: src, Destination: dst}, nil
+}
+
+func parseAddrPort(addr, port string) (netip.AddrPort, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid PROXY protocol address: %w", err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid PROXY protocol port %s", port)
+	}
+	return netip.AddrPortFrom(ip, uint16(p)), nil
+}
+
+func readV2(r *bufio.Reader) (Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return Header{}, err
+	}
+	if fixed[12]>>4 != 2 {
+		return Header{}, fmt.Errorf("unsupported PROXY protocol version %d", fixed[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Header{}, err
+	}
+
+	const local, proxy = 0, 1
+	switch fixed[12] & 0xf {
+	case local:
+		return Header{}, nil
+	case proxy:
+	default:
+		return Header{}, fmt.Errorf("unsupported PROXY protocol command %d", fixed[12]&0xf)
+	}
+
+	// Only TCP over IPv4 and IPv6 carry addresses we can use, remaining
+	// payload holds TLVs that are ignored.
+	var size int
+	switch fixed[13] {
+	case 0x11:
+		size = 4
+	case 0x21:
+		size = 16
+	default:
+		return Header{}, nil
+	}
+	if len(payload) < 2*size+4 {
+		return Header{}, errors.New("PROXY protocol v2 header too short")
+	}
+	src, _ := netip.AddrFromSlice(payload[:size])
+	dst, _ := netip.AddrFromSlice(payload[size : 2*size])
+	ports := payload[2*size:]
+	return Header{
+		Source:      netip.AddrPortFrom(src, binary.BigEndian.Uint16(ports)),
+		Destination: netip.AddrPortFrom(dst, binary.BigEndian.Uint16(ports[2:])),
+	}, nil
+}
+
+// WriteHeader writes a header of given version, 1 or 2, to w. If h does
+// not hold valid addresses, a header without addresses is written.
+func WriteHeader(w io.Writer, version int, h Header) error {
+	src, dst := h.Source, h.Destination
+	valid := src.IsValid() && dst.IsValid()
+	// Both addresses must be of the same family.
+	if valid && src.Addr().Is4() != dst.Addr().Is4() {
+		src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+		dst = netip.AddrPortFrom(netip.AddrFrom16(dst.Addr().As16()), dst.Port())
+	}
+
+	switch version {
+	case 1:
+		line := "PROXY UNKNOWN\r\n"
+		if valid {
+			family := "TCP4"
+			if !src.Addr().Is4() {
+				family = "TCP6"
+			}
+			line = fmt.Sprintf("PROXY %s %s %s %d %d\r\n",
+				family, src.Addr(), dst.Addr(), src.Port(), dst.Port())
+		}
+		_, err := io.WriteString(w, line)
+		return err
+	case 2:
+		buf := append([]byte{}, v2Signature...)
+		if !valid {
+			buf = append(buf, 0x20, 0, 0, 0)
+			_, err := w.Write(buf)
+			return err
+		}
+		family := byte(0x11)
+		if !src.Addr().Is4() {
+			family = 0x21
+		}
+		buf = append(buf, 0x21, family, 0, 0)
+		buf = append(buf, src.Addr().AsSlice()...)
+		buf = append(buf, dst.Addr().AsSlice()...)
+		buf = binary.BigEndian.AppendUint16(buf, src.Port())
+		buf = binary.BigEndian.AppendUint16(buf, dst.Port())
+		binary.BigEndian.PutUint16(buf[14:], uint16(len(buf)-16))
+		_, err := w.Write(buf)
+		return err
+	}
+	return fmt.Errorf("unsupported PROXY protocol version %d", version)
+}
+
+// AddrPort returns IP address and port of addr, or an invalid value if addr
+// is not a TCP or UDP address.
+func AddrPort(addr net.Addr) netip.AddrPort {
+	var ap netip.AddrPort
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ap = a.AddrPort()
+	case *net.UDPAddr:
+		ap = a.AddrPort()
+	default:
+		return ap
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
@@ -0,0 +1,121 @@
+package proxyproto_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/akojo/legion/proxyproto"
+)
+
+func TestReadV1(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+	header, err := proxyproto.ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := proxyproto.Header{
+		Source:      netip.MustParseAddrPort("192.0.2.1:56324"),
+		Destination: netip.MustParseAddrPort("198.51.100.1:443"),
+	}
+	if header != want {
+		t.Errorf("want %v, got %v", want, header)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "GET / HTTP/1.1\r\n" {
+		t.Errorf("data after header: got %q", rest)
+	}
+}
+
+func TestReadV1Unknown(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"))
+	header, err := proxyproto.ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Source.IsValid() || header.Destination.IsValid() {
+		t.Errorf("want no addresses, got %v", header)
+	}
+}
+
+func TestInvalidHeaders(t *testing.T) {
+	tests := map[string]string{
+		"missing":   "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"truncated": "PROXY TCP4 192.0.2.1",
+		"too long":  "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n",
+		"bad addr":  "PROXY TCP4 192.0.2 198.51.100.1 56324 443\r\n",
+		"bad port":  "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n",
+		"v2 short":  "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\x00\x00\x00\x00",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := proxyproto.ReadHeader(bufio.NewReader(strings.NewReader(input)))
+			if err == nil {
+				t.Error("expect error")
+			}
+		})
+	}
+	_, err := proxyproto.ReadHeader(bufio.NewReader(strings.NewReader(tests["missing"])))
+	if !errors.Is(err, proxyproto.ErrNoHeader) {
+		t.Errorf("want ErrNoHeader, got %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	headers := []proxyproto.Header{
+		{
+			Source:      netip.MustParseAddrPort("192.0.2.1:56324"),
+			Destination: netip.MustParseAddrPort("198.51.100.1:443"),
+		},
+		{
+			Source:      netip.MustParseAddrPort("[2001:db8::1]:56324"),
+			Destination: netip.MustParseAddrPort("[2001:db8::2]:443"),
+		},
+		{},
+	}
+	for _, version := range []int{1, 2} {
+		for _, want := range headers {
+			var buf bytes.Buffer
+			if err := proxyproto.WriteHeader(&buf, version, want); err != nil {
+				t.Fatal(err)
+			}
+			buf.WriteString("data")
+			r := bufio.NewReader(&buf)
+			got, err := proxyproto.ReadHeader(r)
+			if err != nil {
+				t.Fatalf("v%d %v: %v", version, want, err)
+			}
+			if got != want {
+				t.Errorf("v%d: want %v, got %v", version, want, got)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "data" {
+				t.Errorf("v%d: data after header: got %q", version, rest)
+			}
+		}
+	}
+}
+
+func TestMixedAddressFamilies(t *testing.T) {
+	var buf bytes.Buffer
+	header := proxyproto.Header{
+		Source:      netip.MustParseAddrPort("192.0.2.1:56324"),
+		Destination: netip.MustParseAddrPort("[2001:db8::2]:443"),
+	}
+	if err := proxyproto.WriteHeader(&buf, 1, header); err != nil {
+		t.Fatal(err)
+	}
+	want := "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 56324 443\r\n"
+	if buf.String() != want {
+		t.Errorf("want %q, got %q", want, buf.String())
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	if err := proxyproto.WriteHeader(io.Discard, 3, proxyproto.Header{}); err == nil {
+		t.Error("expect error")
+	}
+}
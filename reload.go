@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/akojo/legion/config"
+	"github.com/akojo/legion/handler"
+	"github.com/akojo/legion/health"
+	"github.com/akojo/legion/server"
+)
+
+// reloader re-reads configuration on SIGHUP, or when configuration file
+// changes, and replaces routes with new ones.
+type reloader struct {
+	// Configuration server was started with. Listeners and TLS settings
+	// can't be changed without restart.
+	initial   *config.Config
+	routes    *handler.Reloadable
+	upstreams *handler.Upstreams
+	limiters  *handler.Limiters
+	recorder  *handler.HARRecorder
+	health    *health.Checker
+	accessLog *slog.Logger
+	logLevel  *slog.LevelVar
+
+	// Serializes reloads
+	mu      sync.Mutex
+	current atomic.Pointer[config.Config]
+}
+
+func newReloader(conf *config.Config, routes *handler.Reloadable, upstreams *handler.Upstreams, limiters *handler.Limiters, recorder *handler.HARRecorder, checker *health.Checker, accessLog *slog.Logger, logLevel *slog.LevelVar) *reloader {
+	r := &reloader{initial: conf, routes: routes, upstreams: upstreams, limiters: limiters, recorder: recorder, health: checker, accessLog: accessLog, logLevel: logLevel}
+	r.current.Store(conf)
+	return r
+}
+
+// Config returns configuration currently in effect.
+func (r *reloader) Config() *config.Config {
+	return r.current.Load()
+}
+
+// checkUpstreams returns an error if a proxy route in configuration
+// currently in effect has fewer enabled and healthy upstreams than its
+// min_healthy_upstreams requires. Upstreams are only observed through
+// requests proxied to them, which stop once server is not ready, so a failed
+// upstream counts as healthy again after health.upstream_recovery without
+// errors.
+func (r *reloader) checkUpstreams() error {
+	conf := r.Config()
+	for _, route := range conf.Routes.Proxy {
+		required := conf.Health.MinHealthyUpstreams
+		if route.MinHealthyUpstreams != nil {
+			required = *route.MinHealthyUpstreams
+		}
+		s := r.upstreams.Status(route.Target)
+		recovered := !s.Healthy && time.Since(*s.LastErrorTime) >= conf.Health.UpstreamRecovery
+		healthy := 0
+		if s.State == handler.UpstreamEnabled && (s.Healthy || recovered) {
+			healthy++
+		}
+		if healthy < required {
+			return fmt.Errorf("%s: %d of %d required upstreams healthy", route.Source, healthy, required)
+		}
+	}
+	return nil
+}
+
+func (r *reloader) run() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var changes <-chan time.Time
+	if r.initial.Reload.Watch && r.initial.File != "" {
+		changes = watchFile(r.initial.File, r.initial.Reload.Interval)
+	}
+
+	for {
+		select {
+		case <-hup:
+			r.Reload("SIGHUP")
+		case <-changes:
+			r.Reload("configuration file changed")
+		}
+	}
+}
+
+// Reload re-reads configuration and replaces routes. If configuration is
+// invalid, current configuration is kept and an error is returned.
+func (r *reloader) Reload(reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	server.NotifyReloading()
+	defer server.Notify("READY=1")
+	r.health.SetReloading(true)
+	defer r.health.SetReloading(false)
+
+	slog.Info("reloading configuration", "reason", reason)
+	conf, err := config.ReadConfig(os.Args[1:])
+	var h http.Handler
+	if err == nil {
+		h, err = newHandler(conf, r.upstreams, r.limiters, r.recorder, r.health, r.accessLog)
+	}
+	if err != nil {
+		slog.Error("invalid configuration, keeping current configuration", "error", err)
+		return err
+	}
+
+	if !reflect.DeepEqual(listenConfig(r.initial), listenConfig(conf)) {
+		slog.Warn("changes to listeners, TLS and log settings take effect only after restart or upgrade")
+	}
+	// Level changed through admin API is kept unless configured level
+	// changes.
+	if conf.LogLevel != r.Config().LogLevel {
+		r.logLevel.Set(conf.LogLevel.Level)
+	}
+	r.routes.Store(h)
+	r.current.Store(conf)
+	slog.Info("configuration reloaded",
+		"static_routes", len(conf.Routes.Static),
+		"proxy_routes", len(conf.Routes.Proxy))
+	return nil
+}
+
+// listenConfig returns settings that are applied only at startup.
+func listenConfig(conf *config.Config) []any {
+	return []any{conf.AllListeners(), conf.TLS, conf.HTTP3, conf.H2C, conf.Reload, conf.Timeouts, conf.MaxHeaderBytes, conf.Limits, conf.Admin, conf.Tracing, conf.Log, conf.AccessLog}
+}
+
+// watchFile polls filename for changes in modification time or size,
+// sending current time on returned channel when a change is detected.
+func watchFile(filename string, interval time.Duration) <-chan time.Time {
+	changes := make(chan time.Time)
+	go func() {
+		last, _ := os.Stat(filename)
+		for now := range time.Tick(interval) {
+			info, err := os.Stat(filename)
+			if err != nil {
+				continue
+			}
+			if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+				last = info
+				changes <- now
+			}
+		}
+	}()
+	return changes
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package server
+
+import "golang.org/x/sys/unix"
+
+// monotonicUsec returns current CLOCK_MONOTONIC time in microseconds, as
+// systemd expects in MONOTONIC_USEC.
+func monotonicUsec() int64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return ts.Nano() / 1000
+}
@@ -0,0 +1,6 @@
+package server
+
+// There is no systemd on Windows to notify.
+func monotonicUsec() int64 {
+	return 0
+}
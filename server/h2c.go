@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2cServer serves cleartext HTTP/2 on connections taken over from an
+// http.Server. These connections are hijacked, so http.Server doesn't wait
+// for them on shutdown.
+type h2cServer struct {
+	h2s      *http2.Server
+	handlers sync.WaitGroup
+}
+
+// newH2CServer returns a server serving cleartext HTTP/2 connections of srv
+// with handler. Shutting down srv sends GOAWAY on them.
+func newH2CServer(srv *http.Server, handler http.Handler) (*h2cServer, error) {
+	s := &h2cServer{h2s: &http2.Server{}}
+	if err := http2.ConfigureServer(srv, s.h2s); err != nil {
+		return nil, err
+	}
+	h := h2c.NewHandler(handler, s.h2s)
+	// HTTP/2 connections are served until they close by the request that
+	// started them. Other requests are counted as well, but http.Server
+	// waits for them anyway.
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handlers.Add(1)
+		defer s.handlers.Done()
+		h.ServeHTTP(w, r)
+	})
+	return s, nil
+}
+
+// wait waits for HTTP/2 connections to finish once srv has been shut down,
+// or until ctx is done.
+func (s *h2cServer) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/akojo/legion/proxyproto"
+)
+
+// ConnLimits limit number of concurrent connections. Zero means no limit.
+type ConnLimits struct {
+	// MaxConns is maximum number of connections across all listeners.
+	MaxConns int
+	// MaxConnsPerIP is maximum number of connections from a single client
+	// IP address.
+	MaxConnsPerIP int
+}
+
+// connCounter counts connections against global, per-listener and
+// per-client limits.
+type connCounter struct {
+	ConnLimits
+	mu    sync.Mutex
+	total int
+	perIP map[netip.Addr]int
+}
+
+func newConnCounter(limits ConnLimits) *connCounter {
+	return &connCounter{ConnLimits: limits, perIP: make(map[netip.Addr]int)}
+}
+
+// acquire counts a connection from addr on listener l, returning name of
+// exceeded limit if connection is over limits.
+func (c *connCounter) acquire(l *limitListener, addr netip.Addr) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.MaxConns > 0 && c.total >= c.MaxConns:
+		return "max_connections"
+	case l.max > 0 && l.count >= l.max:
+		return "listener_max_connections"
+	case c.MaxConnsPerIP > 0 && addr.IsValid() && c.perIP[addr] >= c.MaxConnsPerIP:
+		return "max_connections_per_ip"
+	}
+	c.total++
+	l.count++
+	if addr.IsValid() {
+		c.perIP[addr]++
+	}
+	return ""
+}
+
+func (c *connCounter) release(l *limitListener, addr netip.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total--
+	l.count--
+	if addr.IsValid() {
+		if c.perIP[addr]--; c.perIP[addr] == 0 {
+			delete(c.perIP, addr)
+		}
+	}
+}
+
+// limitListener counts connections against limits. Connections over limits
+// are responded to with 503 Service Unavailable and closed right after they
+// are accepted. Connections of TLS listeners are closed without response,
+// since it would take a TLS handshake to send one.
+type limitListener struct {
+	net.Listener
+	counter *connCounter
+	max     int
+	respond bool
+	// Guarded by counter.mu
+	count int
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		lc := &limitConn{Conn: conn, listener: l}
+		// Client address of a PROXY protocol connection is only known once
+		// header has been read, which must not block accepting others.
+		if _, ok := conn.(*proxyConn); ok || lc.admit() == "" {
+			return lc, nil
+		}
+		if !l.respond {
+			conn.Close()
+			continue
+		}
+		go func() {
+			respondOverLimit(conn)
+			conn.Close()
+		}()
+	}
+}
+
+// Response to requests on connections over limits.
+const overLimitResponse = "HTTP/1.1 503 Service Unavailable\r\n" +
+	"Connection: close\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Length: 20\r\n" +
+	"\r\n" +
+	"Service Unavailable\n"
+
+// How long request is read after responding to a connection over limits.
+const overLimitLinger = 500 * time.Millisecond
+
+// respondOverLimit responds to a connection over limits with 503 Service
+// Unavailable without waiting for request. Connection is then closed for
+// writing and request read and discarded for a moment, so that closing the
+// connection with an unread request doesn't reset it before client has read
+// the response.
+func respondOverLimit(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(overLimitLinger))
+	if _, err := io.WriteString(conn, overLimitResponse); err != nil {
+		return
+	}
+	raw := conn
+	if pc, ok := conn.(*proxyConn); ok {
+		raw = pc.Conn
+	}
+	if cw, ok := raw.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	io.Copy(io.Discard, conn)
+}
+
+const (
+	connPending = iota
+	connAdmitted
+	connRejected
+	connClosed
+)
+
+var errOverLimit = errors.New("connection limit exceeded")
+
+// limitConn is counted against limits when accepted, or on first read if
+// client address is not known before PROXY protocol header has been read.
+// Reads fail on connections over limits, closing them before anything is
+// served.
+type limitConn struct {
+	net.Conn
+	listener *limitListener
+
+	mu        sync.Mutex
+	state     int
+	reason    string
+	addr      netip.Addr
+	responded bool
+}
+
+// admit counts connection against limits if not done already, returning
+// name of exceeded limit or empty string if connection is within limits.
+func (c *limitConn) admit() string {
+	c.mu.Lock()
+	if c.state != connPending {
+		defer c.mu.Unlock()
+		return c.reason
+	}
+	c.mu.Unlock()
+
+	// Resolving remote address may block reading PROXY protocol header.
+	addr := proxyproto.AddrPort(c.Conn.RemoteAddr()).Addr()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == connPending {
+		c.addr = addr
+		c.reason = c.listener.counter.acquire(c.listener, addr)
+		if c.reason == "" {
+			c.state = connAdmitted
+		} else {
+			c.state = connRejected
+			slog.Warn("connection limit exceeded", "limit", c.reason, "address", c.Conn.RemoteAddr().String())
+		}
+	}
+	return c.reason
+}
+
+func (c *limitConn) Read(b []byte) (int, error) {
+	if c.admit() != "" {
+		c.mu.Lock()
+		respond := c.listener.respond && !c.responded
+		c.responded = true
+		c.mu.Unlock()
+		if respond {
+			respondOverLimit(c.Conn)
+		}
+		return 0, errOverLimit
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *limitConn) Close() error {
+	c.mu.Lock()
+	if c.state == connAdmitted {
+		c.listener.counter.release(c.listener, c.addr)
+	}
+	c.state = connClosed
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestListenerMaxConns(t *testing.T) {
+	addr := limitedServer(t, ConnLimits{}, 1)
+	first := keepAlive(t, addr)
+	if status := first.get(); status != http.StatusOK {
+		t.Fatalf("first connection: want 200, got %d", status)
+	}
+	second := keepAlive(t, addr)
+	if status := second.get(); status != http.StatusServiceUnavailable {
+		t.Errorf("second connection: want 503, got %d", status)
+	}
+
+	first.Close()
+	time.Sleep(50 * time.Millisecond)
+	third := keepAlive(t, addr)
+	if status := third.get(); status != http.StatusOK {
+		t.Errorf("after close: want 200, got %d", status)
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	addr := limitedServer(t, ConnLimits{MaxConns: 2}, 0)
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable} {
+		if status := keepAlive(t, addr).get(); status != want {
+			t.Errorf("connection %d: want %d, got %d", i, want, status)
+		}
+	}
+}
+
+func TestMaxConnsPerIP(t *testing.T) {
+	addr := limitedServer(t, ConnLimits{MaxConnsPerIP: 1}, 0)
+	first := keepAlive(t, addr)
+	if status := first.get(); status != http.StatusOK {
+		t.Fatalf("first connection: want 200, got %d", status)
+	}
+	if status := keepAlive(t, addr).get(); status != http.StatusServiceUnavailable {
+		t.Errorf("second connection: want 503, got %d", status)
+	}
+	// Subsequent requests on an admitted connection are served.
+	if status := first.get(); status != http.StatusOK {
+		t.Errorf("second request: want 200, got %d", status)
+	}
+}
+
+func TestMaxConnsPerIPProxyProtocol(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveLimited(t, &proxyListener{Listener: listener, trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}, ConnLimits{MaxConnsPerIP: 1}, 0)
+	first := keepAlive(t, addr)
+	io.WriteString(first, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+	if status := first.get(); status != http.StatusOK {
+		t.Fatalf("first connection: want 200, got %d", status)
+	}
+	second := keepAlive(t, addr)
+	io.WriteString(second, "PROXY TCP4 192.0.2.1 198.51.100.1 56325 443\r\n")
+	if status := second.get(); status != http.StatusServiceUnavailable {
+		t.Errorf("same client: want 503, got %d", status)
+	}
+	third := keepAlive(t, addr)
+	io.WriteString(third, "PROXY TCP4 192.0.2.2 198.51.100.1 56326 443\r\n")
+	if status := third.get(); status != http.StatusOK {
+		t.Errorf("other client: want 200, got %d", status)
+	}
+}
+
+func TestIdleConnsOverLimit(t *testing.T) {
+	addr := limitedServer(t, ConnLimits{MaxConns: 2}, 0)
+	conns := make([]*client, 4)
+	for i := range conns {
+		conns[i] = keepAlive(t, addr)
+	}
+	for i, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		resp, err := http.ReadResponse(conn.reader, nil)
+		var netErr net.Error
+		timeout := errors.As(err, &netErr) && netErr.Timeout()
+		if open := i < 2; open != timeout {
+			t.Errorf("connection %d: want open %t, got %v", i, open, err)
+		}
+		if i >= 2 && (err != nil || resp.StatusCode != http.StatusServiceUnavailable) {
+			t.Errorf("connection %d: want 503, got %v", i, err)
+		}
+	}
+}
+
+func TestTLSConnsOverLimit(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll := &limitListener{Listener: listener, counter: newConnCounter(ConnLimits{MaxConns: 1})}
+	go func() {
+		for {
+			if _, err := ll.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+
+	keepAlive(t, listener.Addr().String())
+	second := keepAlive(t, listener.Addr().String())
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.reader.ReadByte(); err != io.EOF {
+		t.Errorf("want connection closed without response, got %v", err)
+	}
+}
+
+func limitedServer(t *testing.T, limits ConnLimits, listenerMax int) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveLimited(t, listener, limits, listenerMax)
+}
+
+// serveLimited serves plain HTTP on listener with connection limits and
+// returns its address.
+func serveLimited(t *testing.T, listener net.Listener, limits ConnLimits, listenerMax int) string {
+	ll := &limitListener{Listener: listener, counter: newConnCounter(limits), max: listenerMax, respond: true}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go srv.Serve(ll)
+	t.Cleanup(func() { srv.Close() })
+	return listener.Addr().String()
+}
+
+type client struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func keepAlive(t *testing.T, addr string) *client {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// get sends a request on connection and returns status of response, or 0 if
+// connection was closed.
+func (c *client) get() int {
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err := http.ReadResponse(c.reader, nil)
+	if err != nil {
+		return 0
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Listener describes an address to listen on. Addr is either a TCP address,
+// e.g. ":8000", or a path to a Unix domain socket prefixed with "unix:",
+// e.g. "unix:/run/legion.sock".
+type Listener struct {
+	Addr string
+
+	// Name of the listener. An inherited socket with matching name in
+	// LISTEN_FDNAMES is used instead of binding to Addr.
+	Name string
+
+	// Permissions and ownership of Unix domain socket file. Zero values
+	// leave the defaults set by the operating system.
+	Mode  os.FileMode
+	Owner string
+	Group string
+
+	// ProxyProtocol enables PROXY protocol on listener if set.
+	ProxyProtocol *ProxyProtocol
+
+	// MaxConns is maximum number of concurrent connections on listener.
+	// Zero means no limit.
+	MaxConns int
+}
+
+// ProxyProtocol configures accepting PROXY protocol headers, which replace
+// connection addresses with those of original client. Headers are required
+// from connections coming from Trusted addresses, connections from other
+// addresses are served as is. Connections to Unix domain sockets, access to
+// which is controlled with file permissions, are always trusted.
+type ProxyProtocol struct {
+	Trusted []netip.Prefix
+}
+
+// SocketPath returns the Unix domain socket path of a listener, or false if
+// listener is a TCP listener.
+func (l Listener) SocketPath() (string, bool) {
+	return strings.CutPrefix(l.Addr, "unix:")
+}
+
+// key returns the name an inherited socket must have to be used for the
+// listener.
+func (l Listener) key() string {
+	if l.Name != "" {
+		return l.Name
+	}
+	return l.Addr
+}
+
+func (l Listener) listen() (net.Listener, error) {
+	if path, ok := l.SocketPath(); ok {
+		return l.listenUnix(path)
+	}
+	return net.Listen("tcp", l.Addr)
+}
+
+func (l Listener) listenUnix(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	// Socket file is removed when listener is closed. If permissions are
+	// set, it is created accessible to owner only until they are, so that
+	// others can't connect in between.
+	var listener net.Listener
+	var err error
+	listen := func() { listener, err = net.Listen("unix", path) }
+	if l.Mode != 0 {
+		withUmask(0o077, listen)
+	} else {
+		listen()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := l.setPermissions(path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (l Listener) setPermissions(path string) error {
+	if l.Mode != 0 {
+		if err := os.Chmod(path, l.Mode); err != nil {
+			return err
+		}
+	}
+	if l.Owner == "" && l.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if l.Owner != "" {
+		u, err := user.Lookup(l.Owner)
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("%s: unsupported uid %s", l.Owner, u.Uid)
+		}
+	}
+	if l.Group != "" {
+		g, err := user.LookupGroup(l.Group)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return fmt.Errorf("%s: unsupported gid %s", l.Group, g.Gid)
+		}
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// removeStaleSocket removes a socket file left behind by a previous process
+// that did not shut down cleanly. Socket files with a live server listening
+// on them are left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s: file exists and is not a socket", path)
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s: address already in use", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legion.sock")
+	l := Listener{Addr: "unix:" + path, Mode: 0600}
+
+	listener, err := l.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode: want 0600, got %o", mode)
+	}
+
+	listener.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("want socket file removed on close, got %v", err)
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legion.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := Listener{Addr: "unix:" + path}.listen()
+	if err != nil {
+		t.Fatalf("want stale socket to be replaced, got %v", err)
+	}
+	listener.Close()
+}
+
+func TestSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legion.sock")
+	live, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	if _, err := (Listener{Addr: "unix:" + path}).listen(); err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestSocketPathNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legion.sock")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Listener{Addr: "unix:" + path}).listen(); err == nil {
+		t.Error("expect error")
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+)
+
+// errHandshake is observed for connections closed before their TLS
+// handshake completed.
+var errHandshake = errors.New("TLS handshake failed")
+
+// handshakeListener reports TLS handshakes of connections accepted from it.
+// It is layered below TLS, and handshakes are matched with connections in
+// observeHandshakes.
+type handshakeListener struct {
+	net.Listener
+	observe func(version uint16, err error)
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &handshakeConn{Conn: conn, observe: l.observe}, nil
+}
+
+// handshakeConn reports a failed handshake if closed before handshake has
+// completed.
+type handshakeConn struct {
+	net.Conn
+	observe func(version uint16, err error)
+	once    sync.Once
+}
+
+func (c *handshakeConn) done(version uint16, err error) {
+	c.once.Do(func() {
+		c.observe(version, err)
+	})
+}
+
+func (c *handshakeConn) Close() error {
+	c.done(0, errHandshake)
+	return c.Conn.Close()
+}
+
+// observeHandshakes reports successful handshakes to observe, marking
+// connections from handshakeListener done. Config is cloned per handshake
+// so that connection of each one is known, after GetConfigForClient already
+// set, if any. HTTP/3 handshakes are reported as well, but only when
+// successful.
+func observeHandshakes(conf *tls.Config, observe func(version uint16, err error)) {
+	getConfig := conf.GetConfigForClient
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		base := conf
+		if getConfig != nil {
+			c, err := getConfig(hello)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				base = c
+			}
+		}
+		observed := base.Clone()
+		observed.GetConfigForClient = nil
+		// Called at the end of every successful handshake, including
+		// resumed ones.
+		observed.VerifyConnection = func(state tls.ConnectionState) error {
+			if conn, ok := hello.Conn.(*handshakeConn); ok {
+				conn.done(state.Version, nil)
+			} else {
+				observe(state.Version, nil)
+			}
+			return nil
+		}
+		return observed, nil
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestObserveHandshakes(t *testing.T) {
+	ca := newTestCA(t)
+	certfile, keyfile := ca.issue(t, t.TempDir(), "server", x509.ExtKeyUsageServerAuth)
+	s := New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if err := s.AddTLSCertificate(certfile, keyfile); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var versions []uint16
+	var failed int
+	s.ObserveTLSHandshakes(func(version uint16, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failed++
+		} else {
+			versions = append(versions, version)
+		}
+	})
+	conf, err := s.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	observeHandshakes(conf, s.handshakes)
+	socks, err := bind([]Listener{{Addr: "127.0.0.1:0"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(socks.Close)
+	srv := &http.Server{Handler: s.handler, TLSConfig: conf}
+	go srv.Serve(socks.serving(conf, nil, s.handshakes)[0])
+	t.Cleanup(func() { srv.Close() })
+	addr := socks.listeners[0].Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get("https://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// Go servers require TLS 1.2 by default.
+	if _, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS11}); err == nil {
+		t.Error("want TLS 1.1 handshake to fail")
+	}
+	// Closed without handshake.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		done := failed >= 2
+		mu.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(versions, []uint16{tls.VersionTLS13}) {
+		t.Errorf("successful handshakes: want [TLS 1.3], got %v", versions)
+	}
+	if failed != 2 {
+		t.Errorf("failed handshakes: want 2, got %d", failed)
+	}
+}
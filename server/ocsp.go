@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	ocspMinRefresh = time.Minute
+	ocspMaxBackoff = time.Hour
+	// Refresh interval used when responder does not give NextUpdate.
+	ocspDefaultRefresh = time.Hour
+	ocspTimeout        = 10 * time.Second
+)
+
+// stapler keeps an OCSP response stapled to a certificate, refreshing it
+// before it expires.
+type stapler struct {
+	cert   atomic.Pointer[tls.Certificate]
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+	client *http.Client
+	log    *slog.Logger
+}
+
+func newStapler(cert tls.Certificate) (*stapler, error) {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	s := &stapler{leaf: leaf}
+	s.cert.Store(&cert)
+	if len(leaf.OCSPServer) == 0 {
+		return s, nil
+	}
+
+	if len(cert.Certificate) < 2 {
+		return nil, fmt.Errorf("%s: OCSP stapling requires issuer certificate in chain", leaf.Subject)
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, err
+	}
+	s.issuer = issuer
+	s.client = &http.Client{Timeout: ocspTimeout}
+	s.log = slog.With("certificate", leaf.Subject.String(), "responder", leaf.OCSPServer[0])
+	return s, nil
+}
+
+func (s *stapler) certificate() *tls.Certificate {
+	return s.cert.Load()
+}
+
+// run fetches OCSP responses until ctx is cancelled. On failure it backs off
+// exponentially, keeping the previous response as long as it is valid.
+// Certificates not naming an OCSP responder are left alone.
+func (s *stapler) run(ctx context.Context) {
+	if s.issuer == nil {
+		return
+	}
+	backoff := ocspMinRefresh
+	var expires time.Time
+	for {
+		var wait time.Duration
+		resp, err := s.fetch(ctx)
+		if err != nil {
+			s.log.Warn("OCSP fetch failed", "error", err, "retry", backoff)
+			if !expires.IsZero() && time.Now().After(expires) {
+				s.staple(nil)
+				expires = time.Time{}
+			}
+			wait = backoff
+			backoff = min(2*backoff, ocspMaxBackoff)
+		} else {
+			s.staple(resp.Raw)
+			expires = resp.NextUpdate
+			wait = refreshIn(resp, time.Now())
+			backoff = ocspMinRefresh
+			if resp.Status != ocsp.Good {
+				s.log.Error("certificate is not valid according to OCSP responder", "status", ocspStatus(resp.Status))
+			}
+			s.log.Debug("OCSP response stapled", "next_update", resp.NextUpdate, "refresh", wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *stapler) fetch(ctx context.Context) (*ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(s.leaf, s.issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.leaf.OCSPServer[0], bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	httpReq.Header.Set("Accept", "application/ocsp-response")
+
+	httpResp, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("responder returned %s", httpResp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(body, s.leaf, s.issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+		return nil, errors.New("responder returned an expired response")
+	}
+	return resp, nil
+}
+
+func (s *stapler) staple(raw []byte) {
+	cert := *s.cert.Load()
+	cert.OCSPStaple = raw
+	s.cert.Store(&cert)
+}
+
+// refreshIn returns time to wait before fetching a new response: halfway
+// between ThisUpdate and NextUpdate.
+func refreshIn(resp *ocsp.Response, now time.Time) time.Duration {
+	if resp.NextUpdate.IsZero() {
+		return ocspDefaultRefresh
+	}
+	refresh := resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	return max(refresh.Sub(now), ocspMinRefresh)
+}
+
+func ocspStatus(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// getCertificate selects a certificate for TLS handshake, preferring the
+// first one that supports the client's server name and algorithms.
+func getCertificate(staplers []*stapler) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		for _, s := range staplers {
+			cert := s.certificate()
+			if hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+		return staplers[0].certificate(), nil
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func TestOCSPStapling(t *testing.T) {
+	ca := newTestCA(t)
+	responder := httptest.NewServer(ca.ocspResponder(t, time.Hour))
+	defer responder.Close()
+
+	s := newTestStapler(t, ca, responder.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.run(ctx)
+
+	staple := waitForStaple(t, s)
+	resp, err := ocsp.ParseResponse(staple, ca.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != ocsp.Good {
+		t.Errorf("status: want good, got %s", ocspStatus(resp.Status))
+	}
+}
+
+func TestOCSPResponderDown(t *testing.T) {
+	ca := newTestCA(t)
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer responder.Close()
+
+	s := newTestStapler(t, ca, responder.URL)
+	if _, err := s.fetch(context.Background()); err == nil {
+		t.Error("expect error")
+	}
+	if staple := s.certificate().OCSPStaple; staple != nil {
+		t.Errorf("want no staple, got %d bytes", len(staple))
+	}
+}
+
+func TestNoOCSPResponder(t *testing.T) {
+	ca := newTestCA(t)
+	s := newTestStapler(t, ca, "")
+
+	done := make(chan struct{})
+	go func() {
+		s.run(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("want stapler to exit without OCSP responder")
+	}
+}
+
+func TestOCSPRefresh(t *testing.T) {
+	now := time.Now()
+	resp := &ocsp.Response{ThisUpdate: now, NextUpdate: now.Add(4 * time.Hour)}
+	if got := refreshIn(resp, now); got != 2*time.Hour {
+		t.Errorf("want 2h, got %v", got)
+	}
+	if got := refreshIn(resp, now.Add(3*time.Hour)); got != ocspMinRefresh {
+		t.Errorf("want %v, got %v", ocspMinRefresh, got)
+	}
+	if got := refreshIn(&ocsp.Response{ThisUpdate: now}, now); got != ocspDefaultRefresh {
+		t.Errorf("want %v, got %v", ocspDefaultRefresh, got)
+	}
+}
+
+func waitForStaple(t *testing.T, s *stapler) []byte {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if staple := s.certificate().OCSPStaple; staple != nil {
+			return staple
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for OCSP staple")
+	return nil
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key := newKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) ocspResponder(t *testing.T, validity time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		now := time.Now()
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   now,
+			NextUpdate:   now.Add(validity),
+		}, ca.key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	})
+}
+
+func newTestStapler(t *testing.T, ca *testCA, responder string) *stapler {
+	key := newKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if responder != "" {
+		template.OCSPServer = []string{responder}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newStapler(tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newKey(t *testing.T) crypto.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
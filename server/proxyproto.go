@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/akojo/legion/proxyproto"
+)
+
+// Time allowed for reading PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyListener accepts PROXY protocol headers on connections from trusted
+// addresses, replacing connection addresses with ones in header.
+// Connections over Unix domain sockets are always trusted.
+type proxyListener struct {
+	net.Listener
+	trusted []netip.Prefix
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	ap := proxyproto.AddrPort(addr)
+	if !ap.IsValid() {
+		return true
+	}
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ap.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reads PROXY protocol header on first use, which for connections
+// served by net/http is a call to RemoteAddr before any deadlines are set.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	once   sync.Once
+	header proxyproto.Header
+	err    error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.header, c.err = proxyproto.ReadHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			slog.Warn("invalid PROXY protocol header", "address", c.Conn.RemoteAddr().String(), "error", c.err)
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.header.Source.IsValid() {
+		return net.TCPAddrFromAddrPort(c.header.Source)
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.header.Destination.IsValid() {
+		return net.TCPAddrFromAddrPort(c.header.Destination)
+	}
+	return c.Conn.LocalAddr()
+}
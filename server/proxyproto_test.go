@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestProxyProtocol(t *testing.T) {
+	addr := proxyServer(t, "127.0.0.0/8")
+	got := rawGet(t, addr, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+	if got != "192.0.2.1:56324 198.51.100.1:443" {
+		t.Errorf("want client address from header, got %s", got)
+	}
+}
+
+func TestProxyProtocolV2Local(t *testing.T) {
+	addr := proxyServer(t, "127.0.0.0/8")
+	got := rawGet(t, addr, "\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00")
+	if !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Errorf("want connection address, got %s", got)
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	addr := proxyServer(t, "10.0.0.0/8")
+	got := rawGet(t, addr, "")
+	if !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Errorf("want connection address, got %s", got)
+	}
+}
+
+func TestProxyProtocolMissingHeader(t *testing.T) {
+	addr := proxyServer(t, "127.0.0.0/8")
+	if got := rawGet(t, addr, ""); got != "" {
+		t.Errorf("want request rejected, got %s", got)
+	}
+}
+
+// proxyServer starts a server accepting PROXY protocol from trusted prefix,
+// responding with remote and local addresses of request.
+func proxyServer(t *testing.T, trusted string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := &proxyListener{Listener: listener, trusted: []netip.Prefix{netip.MustParsePrefix(trusted)}}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		local := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		io.WriteString(w, r.RemoteAddr+" "+local.String())
+	})}
+	go srv.Serve(pl)
+	t.Cleanup(func() { srv.Close() })
+	return listener.Addr().String()
+}
+
+// rawGet sends header followed by a GET request to addr, returning response
+// body or empty string if request was not served.
+func rawGet(t *testing.T, addr, header string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, header+"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
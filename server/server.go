@@ -3,23 +3,90 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/quic-go/quic-go/http3"
 )
 
+// ErrShutdownForced is returned by ListenAndServe when a quit signal is
+// received while waiting for active requests to finish.
+var ErrShutdownForced = errors.New("shutdown forced by signal")
+
 type Server struct {
-	handler http.Handler
-	tls     *tls.Config
+	handler      http.Handler
+	certificates []tls.Certificate
+	clientAuth   *clientAuth
+	policy       TLSPolicy
+	ocsp         bool
+	staplers     []*stapler
+	http3Addr    string
+	h2c          bool
+	h2cServer    *h2cServer
+	timeouts     Timeouts
+	maxHeader    int
+	connLimits   ConnLimits
+	admin        *Listener
+	adminHandler http.Handler
+	handshakes   func(version uint16, err error)
+	readiness    func(ready bool)
+}
+
+// Timeouts limit how long connections may take to send requests and receive
+// responses. Zero means no timeout.
+type Timeouts struct {
+	// ReadHeader is time allowed for reading request headers.
+	ReadHeader time.Duration
+	// Read is time allowed for reading entire request, including body.
+	Read time.Duration
+	// Write is time allowed for writing response, from the end of request
+	// headers.
+	Write time.Duration
+	// Idle is how long keep-alive connections are kept open waiting for the
+	// next request. Defaults to Read timeout.
+	Idle time.Duration
+	// Shutdown is how long active requests are given to finish on shutdown
+	// before connections are closed.
+	Shutdown time.Duration
+}
+
+// DefaultTimeouts returns timeouts used unless set with SetTimeouts.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{Shutdown: 30 * time.Second}
+}
+
+func (t Timeouts) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Duration("read_header", t.ReadHeader),
+		slog.Duration("read", t.Read),
+		slog.Duration("write", t.Write),
+		slog.Duration("idle", t.Idle),
+		slog.Duration("shutdown", t.Shutdown),
+	)
+}
+
+type clientAuth struct {
+	auth  tls.ClientAuthType
+	cas   *x509.CertPool
+	hosts []string
 }
 
 func New(handler http.Handler) *Server {
 	return &Server{
-		handler: handler,
+		handler:  handler,
+		policy:   DefaultTLSPolicy(),
+		timeouts: DefaultTimeouts(),
 	}
 }
 
@@ -28,52 +95,386 @@ func (s *Server) AddTLSCertificate(certfile, keyfile string) error {
 	if err != nil {
 		return err
 	}
-	if s.tls == nil {
-		s.tls = &tls.Config{
-			Certificates: []tls.Certificate{},
-			NextProtos:   []string{"h2"},
-		}
+	s.certificates = append(s.certificates, cert)
+	return nil
+}
+
+// SetClientAuth enables client certificate authentication, verifying
+// certificates against CA bundle in cafile. If hosts is non-empty, client
+// certificates are only asked for when TLS server name matches one of the
+// hosts, and requests to the hosts are rejected with 421 Misdirected Request
+// unless TLS server name matches Host.
+func (s *Server) SetClientAuth(auth tls.ClientAuthType, cafile string, hosts []string) error {
+	pem, err := os.ReadFile(cafile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("%s: no certificates found", cafile)
 	}
-	s.tls.Certificates = append(s.tls.Certificates, cert)
+	s.clientAuth = &clientAuth{auth: auth, cas: pool, hosts: hosts}
 	return nil
 }
 
-func (s *Server) ListenAndServe(addr string) error {
-	listener, err := s.listen(addr)
+// SetTLSPolicy sets protocol versions, cipher suites and other TLS
+// parameters used for all TLS connections.
+func (s *Server) SetTLSPolicy(policy TLSPolicy) {
+	s.policy = policy
+}
+
+// EnableOCSPStapling staples OCSP responses to certificates that name an
+// OCSP responder.
+func (s *Server) EnableOCSPStapling() {
+	s.ocsp = true
+}
+
+// EnableHTTP3 serves HTTP/3 on UDP address addr in addition to TCP. HTTP/3
+// requires TLS.
+func (s *Server) EnableHTTP3(addr string) {
+	s.http3Addr = addr
+}
+
+// EnableH2C serves cleartext HTTP/2 on listeners without TLS, both with prior
+// knowledge and via HTTP/1.1 Upgrade. It can't be used with TLS, over which
+// HTTP/2 is served anyway.
+func (s *Server) EnableH2C() {
+	s.h2c = true
+}
+
+// SetTimeouts sets connection timeouts and shutdown grace period.
+func (s *Server) SetTimeouts(timeouts Timeouts) {
+	s.timeouts = timeouts
+}
+
+// SetMaxHeaderBytes limits size of request headers. Zero uses
+// http.DefaultMaxHeaderBytes.
+func (s *Server) SetMaxHeaderBytes(n int) {
+	s.maxHeader = n
+}
+
+// SetConnLimits limits number of concurrent connections. Connections over
+// limits are responded to with 503 Service Unavailable and closed without
+// serving them, or on TLS listeners closed before TLS handshake. Limits apply
+// to TCP and Unix domain socket listeners, not HTTP/3.
+func (s *Server) SetConnLimits(limits ConnLimits) {
+	s.connLimits = limits
+}
+
+// Time allowed for reading requests from and writing responses to admin
+// listener.
+const adminTimeout = 30 * time.Second
+
+// SetAdmin serves handler on a separate listener for administrative
+// endpoints, such as metrics. Admin listener is served without TLS, PROXY
+// protocol or connection limits.
+func (s *Server) SetAdmin(listener Listener, handler http.Handler) {
+	s.admin = &listener
+	s.adminHandler = handler
+}
+
+// ObserveReadiness calls observe with true once server is serving on all
+// listeners, and with false when it starts shutting down.
+func (s *Server) ObserveReadiness(observe func(ready bool)) {
+	s.readiness = observe
+}
+
+// ObserveTLSHandshakes calls observe with TLS version of each successful
+// TLS handshake, and with an error for each connection closed before its
+// handshake completed. Failed HTTP/3 handshakes are not observed.
+func (s *Server) ObserveTLSHandshakes(observe func(version uint16, err error)) {
+	s.handshakes = observe
+}
+
+func (s *Server) ListenAndServe(listeners ...Listener) error {
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if s.http3Addr != "" && tlsConfig == nil {
+		return errors.New("HTTP/3 requires a TLS certificate")
+	}
+	if s.h2c && tlsConfig != nil {
+		return errors.New("h2c can't be used with TLS")
+	}
+	if tlsConfig != nil && s.handshakes != nil {
+		observeHandshakes(tlsConfig, s.handshakes)
+	}
+	all := listeners
+	if s.admin != nil {
+		// Admin listener is bound last so that it is passed on upgrades
+		// along with others.
+		all = append(slices.Clip(listeners), *s.admin)
+	}
+	socks, err := bind(all, s.http3Addr)
 	if err != nil {
 		return err
 	}
 
+	handler := s.handler
+	if s.clientAuth != nil && len(s.clientAuth.hosts) > 0 {
+		handler = s.clientAuth.checkHost(handler)
+	}
+	var h3 *http3.Server
+	if s.http3Addr != "" {
+		h3 = s.http3Server(handler, tlsConfig)
+		handler = altSvc(h3, handler)
+	}
+	var counter *connCounter
+	if s.connLimits != (ConnLimits{}) || slices.ContainsFunc(listeners, func(l Listener) bool { return l.MaxConns > 0 }) {
+		counter = newConnCounter(s.connLimits)
+	}
+
 	srv := &http.Server{
-		Handler:   s.handler,
-		TLSConfig: s.tls,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		ReadTimeout:       s.timeouts.Read,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+		MaxHeaderBytes:    s.maxHeader,
+	}
+	if s.h2c {
+		s.h2cServer, err = newH2CServer(srv, handler)
+		if err != nil {
+			return err
+		}
 	}
 
 	quit := make(chan os.Signal, 1)
-	shutdown := make(chan error)
+	shutdown := make(chan error, len(socks.listeners)+1)
 
-	go func() {
-		err := srv.Serve(listener)
-		if !errors.Is(err, http.ErrServerClosed) {
-			shutdown <- err
+	serving := socks.serving(tlsConfig, counter, s.handshakes)
+	if s.admin != nil {
+		admin := &http.Server{
+			Handler:           s.adminHandler,
+			ReadHeaderTimeout: adminTimeout,
+			WriteTimeout:      adminTimeout,
 		}
-	}()
+		defer admin.Close()
+		go func() {
+			err := admin.Serve(socks.listeners[len(listeners)])
+			if !errors.Is(err, http.ErrServerClosed) {
+				shutdown <- fmt.Errorf("admin: %w", err)
+			}
+		}()
+		serving = serving[:len(listeners)]
+	}
+	for _, listener := range serving {
+		go func() {
+			err := srv.Serve(listener)
+			if !errors.Is(err, http.ErrServerClosed) {
+				shutdown <- err
+			}
+		}()
+	}
+	if h3 != nil {
+		go func() {
+			err := h3.Serve(socks.packetConn)
+			if !errors.Is(err, http.ErrServerClosed) {
+				shutdown <- fmt.Errorf("HTTP/3: %w", err)
+			}
+		}()
+	}
+
+	background, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if tlsConfig != nil && s.policy.TicketKeyRotation > 0 {
+		go rotateTicketKeys(background, s.policy.TicketKeyRotation, tlsConfig)
+	}
+	for _, stapler := range s.staplers {
+		go stapler.run(background)
+	}
 
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	upgradeSignal := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgradeSignal, upgradeSignals...)
+	}
+	signalReady()
+	if s.readiness != nil {
+		s.readiness(true)
+	}
 
-	select {
-	case <-quit:
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	for {
+		select {
+		case <-upgradeSignal:
+			slog.Info("starting new process")
+			pid, err := upgrade(socks, s.http3Addr)
+			if err != nil {
+				slog.Error("upgrade failed, continuing with current process", "error", err)
+				continue
+			}
+			slog.Info("new process ready, shutting down", "pid", pid)
+			Notify("MAINPID=" + strconv.Itoa(pid))
+			socks.keepSocketFiles()
+			if h3 != nil {
+				// Both processes would read from the same UDP socket,
+				// getting packets of each other's QUIC connections, which
+				// can't be handed over. Closing them right away leaves the
+				// socket to the new process, and clients reconnect to it.
+				h3.Close()
+				h3 = nil
+			}
+			return s.shutdownServers(srv, h3, quit)
+		case <-quit:
+			Notify("STOPPING=1")
+			return s.shutdownServers(srv, h3, quit)
+		case err := <-shutdown:
+			srv.Close()
+			if h3 != nil {
+				h3.Close()
+			}
+			return err
+		}
+	}
+}
+
+// shutdownServers gracefully shuts down servers, waiting for active
+// requests to finish until shutdown timeout expires or another quit signal
+// is received, after which remaining connections are closed.
+func (s *Server) shutdownServers(srv *http.Server, h3 *http3.Server, quit <-chan os.Signal) error {
+	if s.readiness != nil {
+		s.readiness(false)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if s.timeouts.Shutdown > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeouts.Shutdown)
 		defer cancel()
-		return srv.Shutdown(ctx)
-	case err := <-shutdown:
-		return err
 	}
+
+	forced := make(chan struct{})
+	go func() {
+		select {
+		case <-quit:
+			slog.Warn("forcing shutdown, closing active connections")
+			close(forced)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	slog.Info("shutting down", "grace_period", s.timeouts.Shutdown)
+	err := srv.Shutdown(ctx)
+	if err == nil && s.h2cServer != nil {
+		err = s.h2cServer.wait(ctx)
+	}
+	if h3 != nil {
+		err = errors.Join(err, h3.Shutdown(ctx))
+	}
+	if err != nil {
+		srv.Close()
+		if h3 != nil {
+			h3.Close()
+		}
+	}
+	select {
+	case <-forced:
+		return ErrShutdownForced
+	default:
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("grace period expired, closed active connections")
+		return nil
+	}
+	return err
 }
 
-func (s *Server) listen(addr string) (net.Listener, error) {
-	if s.tls != nil {
-		return tls.Listen("tcp", addr, s.tls)
+// http3Server returns an HTTP/3 server with same TLS configuration and
+// limits as TCP listeners.
+func (s *Server) http3Server(handler http.Handler, tlsConfig *tls.Config) *http3.Server {
+	return &http3.Server{
+		Addr:           s.http3Addr,
+		Handler:        handler,
+		TLSConfig:      tlsConfig,
+		IdleTimeout:    s.timeouts.Idle,
+		MaxHeaderBytes: s.maxHeader,
 	}
-	return net.Listen("tcp", addr)
+}
+
+// altSvc advertises HTTP/3 endpoint in Alt-Svc header of responses.
+func altSvc(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fails only until HTTP/3 listener is up, in which case there is
+		// nothing to advertise yet.
+		_ = h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if len(s.certificates) == 0 {
+		if s.clientAuth != nil {
+			return nil, errors.New("client authentication requires a TLS certificate")
+		}
+		return nil, nil
+	}
+
+	conf := &tls.Config{Certificates: s.certificates}
+	s.policy.apply(conf)
+
+	if s.ocsp {
+		s.staplers = make([]*stapler, len(s.certificates))
+		for i, cert := range s.certificates {
+			stapler, err := newStapler(cert)
+			if err != nil {
+				return nil, err
+			}
+			s.staplers[i] = stapler
+		}
+		conf.Certificates = nil
+		conf.GetCertificate = getCertificate(s.staplers)
+	}
+
+	if s.clientAuth == nil {
+		return conf, nil
+	}
+	if len(s.clientAuth.hosts) == 0 {
+		conf.ClientAuth = s.clientAuth.auth
+		conf.ClientCAs = s.clientAuth.cas
+		return conf, nil
+	}
+
+	// Config is cloned per handshake so that rotated session ticket keys
+	// apply to client authenticated hosts as well.
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		for _, host := range s.clientAuth.hosts {
+			if strings.EqualFold(hello.ServerName, host) {
+				withAuth := conf.Clone()
+				withAuth.GetConfigForClient = nil
+				withAuth.ClientAuth = s.clientAuth.auth
+				withAuth.ClientCAs = s.clientAuth.cas
+				return withAuth, nil
+			}
+		}
+		return nil, nil
+	}
+	return conf, nil
+}
+
+// checkHost rejects requests to hosts requiring client certificates on
+// connections that were not authenticated for the host. Certificates are
+// asked for based on TLS server name, which a client may set to something
+// other than Host of its requests, or leave out.
+func (a *clientAuth) checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !slices.ContainsFunc(a.hosts, func(h string) bool { return strings.EqualFold(h, host) }) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case r.TLS == nil || !strings.EqualFold(r.TLS.ServerName, host):
+			http.Error(w, http.StatusText(http.StatusMisdirectedRequest), http.StatusMisdirectedRequest)
+		case a.auth == tls.RequireAndVerifyClientCert && len(r.TLS.VerifiedChains) == 0,
+			a.auth == tls.RequireAnyClientCert && len(r.TLS.PeerCertificates) == 0:
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
 }
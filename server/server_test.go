@@ -0,0 +1,332 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	srv, release := slowServer(t)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+
+	s := New(nil)
+	if err := s.shutdownServers(srv, nil, make(chan os.Signal)); err != nil {
+		t.Errorf("want clean shutdown, got %v", err)
+	}
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	srv, release := slowServer(t)
+	defer close(release)
+
+	s := New(nil)
+	s.SetTimeouts(Timeouts{Shutdown: 50 * time.Millisecond})
+	start := time.Now()
+	if err := s.shutdownServers(srv, nil, make(chan os.Signal)); err != nil {
+		t.Errorf("want nil after grace period, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %s", elapsed)
+	}
+}
+
+func TestForcedShutdown(t *testing.T) {
+	srv, release := slowServer(t)
+	defer close(release)
+
+	quit := make(chan os.Signal, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		quit <- syscall.SIGINT
+	}()
+
+	s := New(nil)
+	err := s.shutdownServers(srv, nil, quit)
+	if !errors.Is(err, ErrShutdownForced) {
+		t.Errorf("want ErrShutdownForced, got %v", err)
+	}
+}
+
+func TestShutdownNotReady(t *testing.T) {
+	srv, release := slowServer(t)
+	close(release)
+
+	ready := true
+	s := New(nil)
+	s.ObserveReadiness(func(r bool) {
+		ready = r
+	})
+	if err := s.shutdownServers(srv, nil, make(chan os.Signal)); err != nil {
+		t.Fatal(err)
+	}
+	if ready {
+		t.Error("want not ready on shutdown")
+	}
+}
+
+func TestShutdownWaitsForH2C(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{}
+	s := New(nil)
+	s.h2cServer, err = newH2CServer(srv, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	body := make(chan string, 1)
+	go func() {
+		resp, err := client.Get("http://" + listener.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		body <- resp.Proto + " " + string(b)
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.shutdownServers(srv, nil, make(chan os.Signal))
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned with stream in progress: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Errorf("want clean shutdown, got %v", err)
+	}
+	if got := <-body; got != "HTTP/2.0 done" {
+		t.Errorf("want HTTP/2.0 done, got %q", got)
+	}
+}
+
+func TestClientAuthHosts(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	cafile := filepath.Join(dir, "ca.pem")
+	writePEM(t, cafile, "CERTIFICATE", ca.cert.Raw)
+	certfile, keyfile := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, err := tls.LoadX509KeyPair(ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if err := s.AddTLSCertificate(certfile, keyfile); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetClientAuth(tls.RequireAndVerifyClientCert, cafile, []string{"secure.example"}); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := s.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(s.clientAuth.checkHost(s.handler))
+	srv.TLS = conf
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tests := []struct {
+		name       string
+		serverName string
+		host       string
+		want       int
+	}{
+		{"matching server name", "secure.example", "secure.example", http.StatusOK},
+		{"host with port", "secure.example", "SECURE.example:443", http.StatusOK},
+		{"mismatched server name", "other.example", "secure.example", http.StatusMisdirectedRequest},
+		{"no server name", "", "secure.example", http.StatusMisdirectedRequest},
+		{"other host", "", "other.example", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				ServerName:   tt.serverName,
+				RootCAs:      roots,
+				Certificates: []tls.Certificate{clientCert},
+			}}}
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = tt.host
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("want %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHTTP3(t *testing.T) {
+	ca := newTestCA(t)
+	certfile, keyfile := ca.issue(t, t.TempDir(), "server", x509.ExtKeyUsageServerAuth)
+	s := New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	if err := s.AddTLSCertificate(certfile, keyfile); err != nil {
+		t.Fatal(err)
+	}
+	s.EnableHTTP3("127.0.0.1:0")
+	conf, err := s.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	socks, err := bind([]Listener{{Addr: "127.0.0.1:0"}}, s.http3Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(socks.Close)
+	h3 := s.http3Server(s.handler, conf)
+	go h3.Serve(socks.packetConn)
+	t.Cleanup(func() { h3.Close() })
+	srv := &http.Server{Handler: altSvc(h3, s.handler), TLSConfig: conf}
+	go srv.Serve(socks.serving(conf, nil, nil)[0])
+	t.Cleanup(func() { srv.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tcp := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	udpAddr := socks.packetConn.LocalAddr().(*net.UDPAddr)
+	want := fmt.Sprintf(`h3=":%d"`, udpAddr.Port)
+	// Alt-Svc is set once HTTP/3 listener is up.
+	var altSvc string
+	for range 50 {
+		resp, err := tcp.Get("https://" + socks.listeners[0].Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if altSvc = resp.Header.Get("Alt-Svc"); altSvc != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(altSvc, want) {
+		t.Errorf("Alt-Svc: want %s, got %q", want, altSvc)
+	}
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	defer transport.Close()
+	resp, err := (&http.Client{Transport: transport}).Get("https://" + udpAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 3 || string(body) != "HTTP/3.0" {
+		t.Errorf("want request served over HTTP/3, got %s", body)
+	}
+}
+
+// issue writes a certificate for localhost, 127.0.0.1 and example hosts
+// signed by ca, and its key, to PEM files in dir.
+func (ca *testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (certfile, keyfile string) {
+	key := newKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost", "secure.example", "other.example"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certfile = filepath.Join(dir, name+".pem")
+	keyfile = filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certfile, "CERTIFICATE", der)
+	writePEM(t, keyfile, "PRIVATE KEY", pkcs8)
+	return certfile, keyfile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// slowServer starts a server with one request in progress. The request
+// completes when release is closed.
+func slowServer(t *testing.T) (*http.Server, chan struct{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	go srv.Serve(listener)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	return srv, release
+}
@@ -0,0 +1,6 @@
+package server
+
+import "os"
+
+// Binary upgrades are not supported on Windows.
+var upgradeSignals []os.Signal
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+)
+
+// sockets holds listening sockets of a server before TLS is layered on
+// top, so that they can be passed on to another process.
+type sockets struct {
+	names     []string
+	listeners []net.Listener
+	// Configuration of each listener
+	configs []Listener
+	// UDP socket for HTTP/3, or nil
+	packetConn net.PacketConn
+}
+
+// Name of inherited UDP socket used for HTTP/3.
+func http3Name(addr string) string {
+	return "udp:" + addr
+}
+
+// bind creates listening sockets, preferring inherited ones over binding
+// to an address.
+func bind(listeners []Listener, http3Addr string) (*sockets, error) {
+	inherited, upgrade, err := inheritedFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for name, f := range inherited {
+			slog.Warn("inherited socket does not match any listener", "name", name)
+			f.Close()
+		}
+	}()
+
+	s := &sockets{}
+	for _, l := range listeners {
+		if _, unix := l.SocketPath(); l.ProxyProtocol != nil && !unix && len(l.ProxyProtocol.Trusted) == 0 {
+			s.Close()
+			return nil, fmt.Errorf("%s: PROXY protocol requires trusted addresses", l.Addr)
+		}
+		var listener net.Listener
+		if f, ok := inherited[l.key()]; ok {
+			delete(inherited, l.key())
+			listener, err = fileListener(f, upgrade)
+			if err == nil {
+				slog.Info("using inherited socket", "name", l.key(), "address", listener.Addr().String())
+			}
+		} else {
+			listener, err = l.listen()
+		}
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.names = append(s.names, l.key())
+		s.listeners = append(s.listeners, listener)
+		s.configs = append(s.configs, l)
+	}
+
+	if http3Addr == "" {
+		return s, nil
+	}
+	if f, ok := inherited[http3Name(http3Addr)]; ok {
+		delete(inherited, http3Name(http3Addr))
+		s.packetConn, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		s.packetConn, err = net.ListenPacket("udp", http3Addr)
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("HTTP/3: %w", err)
+	}
+	return s, nil
+}
+
+// fileListener creates a listener from an inherited file. FileListener
+// duplicates file descriptor with close-on-exec set, the original one is
+// closed right away.
+func fileListener(f *os.File, upgrade bool) (net.Listener, error) {
+	defer f.Close()
+	listener, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited socket %s: %w", f.Name(), err)
+	}
+	// Unix domain sockets passed by systemd are owned by systemd, those
+	// passed by parent process are ours to clean up.
+	if unix, ok := listener.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(upgrade)
+	}
+	return listener, nil
+}
+
+// serving returns listeners with PROXY protocol, connection limits and TLS
+// layered on top where configured. TLS handshakes are reported to
+// handshakes, if not nil.
+func (s *sockets) serving(tlsConfig *tls.Config, counter *connCounter, handshakes func(version uint16, err error)) []net.Listener {
+	listeners := make([]net.Listener, len(s.listeners))
+	for i, l := range s.listeners {
+		if pp := s.configs[i].ProxyProtocol; pp != nil {
+			l = &proxyListener{Listener: l, trusted: pp.Trusted}
+		}
+		if counter != nil {
+			l = &limitListener{Listener: l, counter: counter, max: s.configs[i].MaxConns, respond: tlsConfig == nil}
+		}
+		if tlsConfig != nil {
+			if handshakes != nil {
+				l = &handshakeListener{Listener: l, observe: handshakes}
+			}
+			l = tls.NewListener(l, tlsConfig)
+		}
+		listeners[i] = l
+	}
+	return listeners
+}
+
+// files returns file descriptors and names of all sockets for passing to
+// a child process.
+func (s *sockets) files(http3Addr string) ([]*os.File, []string, error) {
+	type filer interface {
+		File() (*os.File, error)
+	}
+	var files []*os.File
+	var names []string
+	add := func(name string, socket any) error {
+		f, err := socket.(filer).File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		names = append(names, fdName(name))
+		return nil
+	}
+	for i, l := range s.listeners {
+		if err := add(s.names[i], l); err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+	}
+	if s.packetConn != nil {
+		if err := add(http3Name(http3Addr), s.packetConn); err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+	}
+	return files, names, nil
+}
+
+// keepSocketFiles prevents removing Unix domain socket files on close when
+// they have been handed over to another process.
+func (s *sockets) keepSocketFiles() {
+	for _, l := range s.listeners {
+		if unix, ok := l.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+func (s *sockets) Close() {
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	if s.packetConn != nil {
+		s.packetConn.Close()
+	}
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
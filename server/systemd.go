@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// First file descriptor passed by systemd socket activation.
+const listenFdsStart = 3
+
+// inheritedFiles returns listening sockets passed by systemd socket
+// activation or by a parent process during an upgrade, keyed by their names
+// in LISTEN_FDNAMES. upgrade reports whether sockets came from a parent
+// legion process, whose names are escaped with fdName. Names set by systemd
+// can't contain ':' and are used as is. Environment variables are cleared so
+// that child processes won't inherit them.
+func inheritedFiles() (files map[string]*os.File, upgrade bool, err error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		os.Unsetenv(envUpgradePID)
+	}()
+
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	ppid, _ := strconv.Atoi(os.Getenv(envUpgradePID))
+	upgrade = ppid != 0 && ppid == os.Getppid()
+	if pid != os.Getpid() && !upgrade {
+		return nil, false, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, false, fmt.Errorf("LISTEN_FDS: %w", err)
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	files = make(map[string]*os.File, count)
+	for i := range count {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		if upgrade {
+			name, err = url.QueryUnescape(name)
+			if err != nil {
+				return nil, false, fmt.Errorf("LISTEN_FDNAMES: %w", err)
+			}
+		}
+		files[name] = os.NewFile(uintptr(listenFdsStart+i), name)
+	}
+	return files, upgrade, nil
+}
+
+// fdName escapes name for LISTEN_FDNAMES passed to a child process, where
+// ':' separates names. Listener names default to addresses, which contain
+// ':'.
+func fdName(name string) string {
+	return url.QueryEscape(name)
+}
+
+// Notify sends a state notification, e.g. "READY=1", to systemd, logging a
+// warning if it can't be sent. Does nothing if service manager did not ask
+// for notifications.
+func Notify(state string) {
+	if err := sendNotification(state); err != nil {
+		slog.Warn("systemd notification failed", "state", state, "error", err)
+	}
+}
+
+// NotifyReloading tells systemd that configuration is being reloaded. Once
+// done, reloading must be followed by "READY=1".
+func NotifyReloading() {
+	Notify(fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d", monotonicUsec()))
+}
+
+func sendNotification(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' {
+		// Abstract socket
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
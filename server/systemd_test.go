@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNotify(t *testing.T) {
+	conn := notifySocket(t)
+	if err := sendNotification("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readNotification(t, conn); got != "READY=1" {
+		t.Errorf("want READY=1, got %#v", got)
+	}
+}
+
+func TestNotifyReloading(t *testing.T) {
+	conn := notifySocket(t)
+	NotifyReloading()
+	got := readNotification(t, conn)
+	usec, ok := strings.CutPrefix(got, "RELOADING=1\nMONOTONIC_USEC=")
+	if n, err := strconv.ParseInt(usec, 10, 64); !ok || err != nil || n <= 0 {
+		t.Errorf("want RELOADING=1 with MONOTONIC_USEC, got %#v", got)
+	}
+}
+
+// notifySocket listens for notifications sent to NOTIFY_SOCKET.
+func notifySocket(t *testing.T) *net.UnixConn {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotifyWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sendNotification("READY=1"); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+}
+
+func TestInheritedListeners(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	f, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestInheritedListenersHelper")
+	cmd.Env = append(os.Environ(),
+		"LEGION_TEST_HELPER=1",
+		"LISTEN_FDS=1",
+		// Names set by systemd are not escaped.
+		"LISTEN_FDNAMES=http+tls")
+	cmd.ExtraFiles = []*os.File{f}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	want := "http+tls=" + listener.Addr().String()
+	if got := string(out); !strings.HasPrefix(got, want) {
+		t.Errorf("want %#v, got %#v", want, got)
+	}
+}
+
+// TestInheritedListenersHelper runs in a child process started by
+// TestInheritedListeners.
+func TestInheritedListenersHelper(t *testing.T) {
+	if os.Getenv("LEGION_TEST_HELPER") != "1" {
+		t.Skip("helper process")
+	}
+	// Normally set by systemd between fork and exec.
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	files, _, err := inheritedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, f := range files {
+		l, err := fileListener(f, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("%s=%s\n", name, l.Addr())
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("want LISTEN_FDS cleared")
+	}
+}
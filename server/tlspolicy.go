@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// TLSPolicy defines TLS protocol parameters. Zero values leave the choice to
+// Go's crypto/tls defaults.
+type TLSPolicy struct {
+	MinVersion        uint16
+	CipherSuites      []uint16
+	CurvePreferences  []tls.CurveID
+	NextProtos        []string
+	TicketKeyRotation time.Duration
+}
+
+// Number of session ticket keys kept around after rotation, so that tickets
+// issued with recent keys can still be resumed.
+const ticketKeyCount = 3
+
+// DefaultTLSPolicy returns Go's default TLS settings with ALPN advertising
+// both HTTP/2 and HTTP/1.1.
+func DefaultTLSPolicy() TLSPolicy {
+	return TLSPolicy{NextProtos: []string{"h2", "http/1.1"}}
+}
+
+// TLSPreset returns a named TLS policy. Supported presets follow Mozilla's
+// server side TLS recommendations:
+//   - modern: TLS 1.3 only
+//   - intermediate: TLS 1.2 and 1.3 with forward secret AEAD cipher suites
+//
+// Key exchange mechanisms are left to Go's defaults, which include hybrid
+// post-quantum X25519MLKEM768 along with the curves Mozilla recommends.
+func TLSPreset(name string) (TLSPolicy, error) {
+	policy := DefaultTLSPolicy()
+	switch name {
+	case "modern":
+		policy.MinVersion = tls.VersionTLS13
+	case "intermediate":
+		policy.MinVersion = tls.VersionTLS12
+		policy.CipherSuites = []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		}
+	default:
+		return TLSPolicy{}, fmt.Errorf("%s: unknown TLS preset", name)
+	}
+	return policy, nil
+}
+
+func (p TLSPolicy) apply(conf *tls.Config) {
+	conf.MinVersion = p.MinVersion
+	conf.CipherSuites = p.CipherSuites
+	conf.CurvePreferences = p.CurvePreferences
+	conf.NextProtos = p.NextProtos
+}
+
+func (p TLSPolicy) LogValue() slog.Value {
+	minVersion := "default"
+	if p.MinVersion != 0 {
+		minVersion = tls.VersionName(p.MinVersion)
+	}
+	ciphers := make([]string, len(p.CipherSuites))
+	for i, id := range p.CipherSuites {
+		ciphers[i] = tls.CipherSuiteName(id)
+	}
+	curves := make([]string, len(p.CurvePreferences))
+	for i, id := range p.CurvePreferences {
+		curves[i] = id.String()
+	}
+	rotation := "disabled"
+	if p.TicketKeyRotation > 0 {
+		rotation = p.TicketKeyRotation.String()
+	}
+	return slog.GroupValue(
+		slog.String("min_version", minVersion),
+		slog.String("cipher_suites", joinOrDefault(ciphers)),
+		slog.String("curves", joinOrDefault(curves)),
+		slog.String("alpn", strings.Join(p.NextProtos, ",")),
+		slog.String("ticket_key_rotation", rotation))
+}
+
+func joinOrDefault(values []string) string {
+	if len(values) == 0 {
+		return "default"
+	}
+	return strings.Join(values, ",")
+}
+
+func rotateTicketKeys(ctx context.Context, interval time.Duration, conf *tls.Config) {
+	var keys [][32]byte
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var key [32]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			slog.Error("session ticket key rotation failed", "error", err)
+		} else {
+			keys = append([][32]byte{key}, keys...)
+			if len(keys) > ticketKeyCount {
+				keys = keys[:ticketKeyCount]
+			}
+			conf.SetSessionTicketKeys(keys)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
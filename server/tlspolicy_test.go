@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"testing"
+)
+
+func TestTLSPolicyOnListener(t *testing.T) {
+	ca := newTestCA(t)
+	certfile, keyfile := ca.issue(t, t.TempDir(), "server", x509.ExtKeyUsageServerAuth)
+	policy, err := TLSPreset("modern")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.NextProtos = []string{"http/1.1"}
+	addr := tlsServer(t, certfile, keyfile, policy)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dial := func(conf *tls.Config) (tls.ConnectionState, error) {
+		conf.RootCAs = roots
+		conf.ServerName = "localhost"
+		conn, err := tls.Dial("tcp", addr, conf)
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState(), nil
+	}
+
+	state, err := dial(&tls.Config{NextProtos: []string{"h2", "http/1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != tls.VersionTLS13 {
+		t.Errorf("version: want TLS 1.3, got %s", tls.VersionName(state.Version))
+	}
+	if state.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("ALPN: want http/1.1, got %q", state.NegotiatedProtocol)
+	}
+	if _, err := dial(&tls.Config{MaxVersion: tls.VersionTLS12}); err == nil {
+		t.Error("want TLS 1.2 handshake to fail")
+	}
+	if _, err := dial(&tls.Config{CurvePreferences: []tls.CurveID{tls.X25519MLKEM768}}); err != nil {
+		t.Errorf("want X25519MLKEM768 enabled, got %v", err)
+	}
+}
+
+// tlsServer serves on a listener bound like ListenAndServe does, with TLS
+// policy applied, and returns its address.
+func tlsServer(t *testing.T, certfile, keyfile string, policy TLSPolicy) string {
+	s := New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if err := s.AddTLSCertificate(certfile, keyfile); err != nil {
+		t.Fatal(err)
+	}
+	s.SetTLSPolicy(policy)
+	conf, err := s.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	socks, err := bind([]Listener{{Addr: "127.0.0.1:0"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(socks.Close)
+	srv := &http.Server{Handler: s.handler, TLSConfig: conf}
+	go srv.Serve(socks.serving(conf, nil, nil)[0])
+	t.Cleanup(func() { srv.Close() })
+	return socks.listeners[0].Addr().String()
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package server
+
+import "syscall"
+
+// withUmask calls f with file mode creation mask of the process set to mask.
+// The mask is process wide, so it applies to files created by other
+// goroutines meanwhile as well.
+func withUmask(mask int, f func()) {
+	old := syscall.Umask(mask)
+	defer syscall.Umask(old)
+	f()
+}
@@ -0,0 +1,6 @@
+package server
+
+// Windows has no file mode creation mask.
+func withUmask(mask int, f func()) {
+	f()
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Set for a child process to accept sockets passed by its parent.
+	envUpgradePID = "LEGION_UPGRADE_PID"
+	// File descriptor a child process writes to when it is ready.
+	envReadyFD = "LEGION_READY_FD"
+
+	upgradeTimeout = 30 * time.Second
+)
+
+// upgrade starts a new process from current executable, passing listening
+// sockets to it, and waits until the new process reports being ready to
+// serve. On failure the new process is killed.
+func upgrade(socks *sockets, http3Addr string) (pid int, err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	files, names, err := socks.files(http3Addr)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFiles(files)
+
+	ready, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(os.Environ(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		envUpgradePID+"="+strconv.Itoa(os.Getpid()),
+		envReadyFD+"="+strconv.Itoa(listenFdsStart+len(files)))
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := ready.Read(buf); err != nil {
+			result <- errors.New("new process exited before becoming ready")
+		} else {
+			result <- nil
+		}
+	}()
+
+	select {
+	case err = <-result:
+	case <-time.After(upgradeTimeout):
+		err = errors.New("timed out waiting for new process to become ready")
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	pid = cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+// signalReady tells systemd and parent process, if any, that server is
+// ready to serve requests.
+func signalReady() {
+	Notify("READY=1")
+
+	fd, err := strconv.Atoi(os.Getenv(envReadyFD))
+	os.Unsetenv(envReadyFD)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
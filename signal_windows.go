@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// Log files can't be reopened by signal on Windows.
+var reopenSignals []os.Signal
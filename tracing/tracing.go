@@ -0,0 +1,221 @@
+// Package tracing traces requests with OpenTelemetry. Trace context is
+// propagated in W3C traceparent and tracestate headers, as well as B3
+// headers, and spans are exported over OTLP.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/akojo/legion/logger"
+)
+
+const tracerName = "github.com/akojo/legion"
+
+// Options configure exporting spans.
+type Options struct {
+	// Protocol used for exporting spans, either "grpc" or "http".
+	Protocol string
+	// Endpoint is URL of OTLP collector, e.g. http://localhost:4317.
+	// Collector on localhost is used by default, without TLS.
+	Endpoint string
+	// ServiceName identifies spans exported by this process.
+	ServiceName string
+	// SampleRatio is fraction of traces sampled, unless trace is continued
+	// from a request with sampling decision made upstream.
+	SampleRatio float64
+}
+
+var provider *sdktrace.TracerProvider
+
+// Start exports spans of traced requests as configured by opts. Requests
+// are not traced unless Start is called.
+func Start(ctx context.Context, opts Options) error {
+	exporter, err := newExporter(ctx, opts)
+	if err != nil {
+		return err
+	}
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(opts.ServiceName)))
+	if err != nil {
+		return err
+	}
+	install(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio)))))
+	return nil
+}
+
+// install sets tp as global tracer provider.
+func install(tp *sdktrace.TracerProvider) {
+	provider = tp
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+		b3.New(b3.WithInjectEncoding(b3.B3SingleHeader|b3.B3MultipleHeader))))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("tracing error", "error", err)
+	}))
+}
+
+func newExporter(ctx context.Context, opts Options) (sdktrace.SpanExporter, error) {
+	switch opts.Protocol {
+	case "grpc":
+		if opts.Endpoint == "" {
+			return otlptracegrpc.New(ctx, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(opts.Endpoint))
+	case "http":
+		if opts.Endpoint == "" {
+			return otlptracehttp.New(ctx, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(opts.Endpoint))
+	}
+	return nil, fmt.Errorf("%s: unsupported OTLP protocol", opts.Protocol)
+}
+
+// Shutdown exports pending spans and stops tracing.
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Middleware starts a server span for each request, continuing trace
+// propagated in request headers, and adds trace ID to access log.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if provider == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(tracerName).Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ServerAddress(r.Host),
+				semconv.NetworkProtocolVersion(protoVersion(r)),
+				semconv.UserAgentOriginal(r.UserAgent())))
+		defer span.End()
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			span.SetAttributes(semconv.ClientAddress(host))
+		}
+		if sc := span.SpanContext(); sc.IsValid() {
+			logger.AddAttrs(ctx, slog.String("trace_id", sc.TraceID().String()))
+		}
+
+		writer := &responseWriter{ResponseWriter: w, status: 200}
+		next.ServeHTTP(writer, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(writer.status))
+		if writer.status >= 500 {
+			span.SetStatus(codes.Error, "")
+			span.SetAttributes(attribute.String("error.type", strconv.Itoa(writer.status)))
+		}
+	})
+}
+
+// SetRoute names server span of request with context ctx after source of
+// matching route.
+func SetRoute(ctx context.Context, method, source string) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.SetName(method + " " + source)
+	span.SetAttributes(semconv.HTTPRoute(source))
+}
+
+// Transport returns a round tripper that starts a client span for each
+// request sent with rt, and propagates trace context to upstream in request
+// headers.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	return &transport{RoundTripper: rt}
+}
+
+type transport struct {
+	http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !trace.SpanContextFromContext(r.Context()).IsValid() {
+		return t.RoundTripper.RoundTrip(r)
+	}
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLFull(r.URL.String()),
+			semconv.ServerAddress(r.URL.Hostname())))
+	defer span.End()
+
+	// Round trippers must not modify requests given to them. Headers
+	// copied from incoming request refer to parent of server span and are
+	// replaced.
+	r = r.WithContext(ctx)
+	r.Header = r.Header.Clone()
+	propagator := otel.GetTextMapPropagator()
+	for _, field := range propagator.Fields() {
+		r.Header.Del(field)
+	}
+	// Not injected, and not listed in propagator fields.
+	r.Header.Del("X-B3-ParentSpanId")
+	propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	resp, err := t.RoundTripper.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, "")
+		span.SetAttributes(attribute.String("error.type", strconv.Itoa(resp.StatusCode)))
+	}
+	return resp, nil
+}
+
+// protoVersion returns HTTP version of request, e.g. 1.1 or 2.
+func protoVersion(r *http.Request) string {
+	if r.ProtoMajor > 1 {
+		return strconv.Itoa(r.ProtoMajor)
+	}
+	return strconv.Itoa(r.ProtoMajor) + "." + strconv.Itoa(r.ProtoMinor)
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	rw.status = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
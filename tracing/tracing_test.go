@@ -0,0 +1,141 @@
+package tracing
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/akojo/legion/logger"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestPropagation(t *testing.T) {
+	spans := recordSpans(t)
+
+	var upstream http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r.Context(), r.Method, "/api")
+		out, _ := http.NewRequestWithContext(r.Context(), "GET", backend.URL, nil)
+		// Propagation headers copied from incoming request.
+		out.Header = r.Header.Clone()
+		resp, err := Transport(http.DefaultTransport).RoundTrip(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}))
+	r := httptest.NewRequest("GET", "/api/users", nil)
+	r.Header.Set("X-B3-TraceId", "4bf92f3577b34da6a3ce929d0e0e4736")
+	r.Header.Set("X-B3-SpanId", "00f067aa0ba902b7")
+	r.Header.Set("X-B3-ParentSpanId", "05e3ac9a4f6e3b90")
+	r.Header.Set("X-B3-Sampled", "1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("want client and server spans, got %d", len(ended))
+	}
+	client, server := ended[0], ended[1]
+	if server.Name() != "GET /api" || server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span: got %s %s", server.SpanKind(), server.Name())
+	}
+	if client.SpanKind() != trace.SpanKindClient {
+		t.Errorf("client span: got %s", client.SpanKind())
+	}
+	if got := server.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("want trace continued from B3 headers, got trace ID %s", got)
+	}
+	if server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span: want remote parent, got %s", server.Parent().SpanID())
+	}
+	if client.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("client span: want server span as parent")
+	}
+
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + client.SpanContext().SpanID().String() + "-01"
+	if got := upstream.Get("Traceparent"); got != want {
+		t.Errorf("traceparent: want %s, got %s", want, got)
+	}
+	if got := upstream.Get("X-B3-SpanId"); got != client.SpanContext().SpanID().String() {
+		t.Errorf("X-B3-SpanId: want client span, got %s", got)
+	}
+	if got := upstream.Get("X-B3-ParentSpanId"); got != "" {
+		t.Errorf("X-B3-ParentSpanId: want removed, got %s", got)
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	spans := recordSpans(t)
+	var log bytes.Buffer
+	h := logger.Middleware(slog.New(slog.NewTextHandler(&log, nil)),
+		Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Traceparent", traceparent)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !strings.Contains(log.String(), "trace_id=4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Errorf("want trace ID in access log, got %s", log.String())
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("want 1 span, got %d", len(ended))
+	}
+	if got := ended[0].SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("want trace continued from traceparent, got trace ID %s", got)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	spans := recordSpans(t)
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	span := spans.Ended()[0]
+	if span.Status().Code.String() != "Error" {
+		t.Errorf("want error status, got %s", span.Status().Code)
+	}
+}
+
+func TestUntraced(t *testing.T) {
+	var upstream http.Header
+	rt := Transport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		upstream = r.Header
+		return &http.Response{StatusCode: 200}, nil
+	}))
+	r := httptest.NewRequest("GET", "http://backend/", nil)
+	r.Header.Set("Traceparent", traceparent)
+	rt.RoundTrip(r)
+	if got := upstream.Get("Traceparent"); got != traceparent {
+		t.Errorf("want traceparent passed through, got %s", got)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// recordSpans installs a tracer provider recording spans in memory.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	install(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { provider = nil })
+	return recorder
+}